@@ -5,6 +5,7 @@ import (
 	"math"
 	"os"
 	"strings"
+	"time"
 )
 
 // ////
@@ -24,23 +25,42 @@ func (sl *stringList) Set(value string) error {
 //////
 
 type cmdlnArgs struct {
-	projIDs  stringList
-	format   string
-	logs     stringList
-	filters  stringList
-	limit    int
-	buffered bool
+	configs     stringList
+	projIDs     stringList
+	format      string
+	logs        stringList
+	filters     stringList
+	limit       int
+	buffered    bool
+	metricsAddr string
+	checkpoint  string
+	summary     string
+	orderWindow time.Duration
+	dedup       bool
+	watch       bool
 }
 
 var _args cmdlnArgs
 
+// The flags for picking what to tail. Subcommands use them too.
+func addSourceFlags(fs *flag.FlagSet, a *cmdlnArgs, limit int) {
+	fs.Var(&a.configs, "config", "Config file (multiple ok, merged in order). Read from stdin if not set")
+	fs.Var(&a.projIDs, "p", "Project ID (multiple ok)")
+	fs.Var(&a.logs, "l", "Log to tail (short name, multiple ok)")
+	fs.Var(&a.filters, "f", "Filter expression (multiple ok)")
+	fs.IntVar(&a.limit, "limit", limit, "Number of entries to output.")
+}
+
 func parseArgs() *cmdlnArgs {
-	flag.Var(&_args.projIDs, "p", "Project ID (multiple ok)")
+	addSourceFlags(flag.CommandLine, &_args, math.MaxInt)
 	flag.StringVar(&_args.format, "format", "yaml", "Format: jsonl,yaml,csv")
-	flag.Var(&_args.logs, "l", "Log to tail (short name, multiple ok)")
-	flag.Var(&_args.filters, "f", "Filter expression (multiple ok)")
-	flag.IntVar(&_args.limit, "limit", math.MaxInt, "Number of entries to output.")
 	flag.BoolVar(&_args.buffered, "buffered", false, "Buffered stdout")
+	flag.StringVar(&_args.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090)")
+	flag.StringVar(&_args.checkpoint, "checkpoint", "", "Write the last entry pulled per project to this file on exit")
+	flag.StringVar(&_args.summary, "summary", "", "Write a run summary on exit: stderr or a JSON file path")
+	flag.DurationVar(&_args.orderWindow, "order-window", 0, "Output in timestamp order, waiting this long for late entries (e.g. 5s)")
+	flag.BoolVar(&_args.dedup, "dedup", false, "Drop entries already seen (by insertId and logName)")
+	flag.BoolVar(&_args.watch, "watch", false, "Reload the config when the -config files change")
 	version := flag.Bool("version", false, "Show version info")
 
 	flag.Usage = func() {
@@ -48,6 +68,9 @@ func parseArgs() *cmdlnArgs {
 		stderrln("  An application that tails GCP Cloud Logging and lets you ")
 		stderrln("  customize the output. See the README for details:")
 		stderrln("  https://github.com/zonkhead/log-tailor\n")
+		stderrln("Commands:")
+		stderrln("  discover    List the paths found in entries (-h for options)")
+		stderrln("  validate    Check a config and list everything wrong with it\n")
 		stderrln("Options:")
 		flag.PrintDefaults()
 	}
@@ -4,41 +4,58 @@ import (
 	logger "log"
 	"os"
 
-	"gopkg.in/yaml.v3"
+	"github.com/zonkhead/log-tailor/tailor"
 )
 
-type OutputMap map[string]any
-
+// The CLI's config: the tailoring part plus where entries come from and
+// how they're written.
 type Config struct {
-	Limit     int
-	Format    string
-	MatchRule string      `yaml:"match-rule"`
-	Projects  []string    `yaml:"projects"`
-	Common    []OutputMap `yaml:"common-output"`
-	Logs      []Log       `yaml:"logs"`
-	Filters   []string    `yaml:"filters"`
-	Buffered  bool
+	tailor.Config `yaml:",inline"`
+
+	Limit    int
+	Format   string
+	Projects []string `yaml:"projects"`
+	Filters  []string `yaml:"filters"`
+	Buffered bool
+	// Where to serve Prometheus metrics, e.g. ":9090". Off if empty.
+	MetricsAddr string `yaml:"metrics-addr"`
+	// Metrics derived from the log entries. Served with the ones above.
+	Metrics []MetricDef `yaml:"metrics"`
+	// File to write the last entry pulled per project to on exit.
+	Checkpoint string `yaml:"checkpoint"`
+	// Where to write the run summary at exit: stderr or a JSON file.
+	Summary string `yaml:"summary"`
+	// Emit entries in timestamp order. Off if nil.
+	Ordering *Ordering `yaml:"ordering"`
+	// Drop entries with an insertId+logName we've already seen. Off if nil.
+	Dedup *Dedup `yaml:"dedup"`
+	// Send records somewhere other than stdout.
+	Sink *SinkDef `yaml:"sink"`
+	// Where alerts go. stderr if nil.
+	AlertSink *SinkDef `yaml:"alert-sink"`
 }
 
-type Log struct {
-	Name    string      `yaml:"name"`
-	ResType string      `yaml:"type"`
-	Output  []OutputMap `yaml:"output"`
-}
+// Reads the yaml config from the -config files, or stdin if there aren't any
+func getConfig(args *cmdlnArgs) *Config {
+	var files []string
+	if args != nil {
+		files = args.configs
+	}
+	doc, err := loadConfig(files)
+	if err != nil {
+		logger.Printf("Error reading config: %v\n", err)
+		os.Exit(1)
+	}
 
-// Reads the yaml config from stdin
-func getConfig(data []byte, args *cmdlnArgs) *Config {
-	// First, check to see if there actually is stdin data.
-	if data == nil {
+	// No config at all, so it's all command line.
+	if doc.root == nil {
 		config := &Config{}
 		return config.setDefaults().overrideFields(args)
 	}
 
-	var config Config
-
-	err := yaml.Unmarshal(data, &config)
-	if err != nil {
-		logger.Printf("Error parsing YAML: %v\n", err)
+	config, problems := checkConfig(doc)
+	if len(problems) > 0 {
+		reportProblems(problems)
 		os.Exit(1)
 	}
 
@@ -47,7 +64,7 @@ func getConfig(data []byte, args *cmdlnArgs) *Config {
 		config.MatchRule = "all"
 	}
 
-	return config.overrideFields(args).validatePaths()
+	return config.overrideFields(args)
 }
 
 func (c *Config) setDefaults() *Config {
@@ -72,9 +89,9 @@ func (c *Config) overrideFields(args *cmdlnArgs) *Config {
 	}
 
 	if len(args.logs) > 0 {
-		newLogs := []Log{}
+		newLogs := []tailor.Log{}
 		for _, l := range args.logs {
-			newLogs = append(newLogs, Log{Name: l})
+			newLogs = append(newLogs, tailor.Log{Name: l})
 		}
 		c.Logs = newLogs
 	}
@@ -94,48 +111,30 @@ func (c *Config) overrideFields(args *cmdlnArgs) *Config {
 
 	c.Buffered = args.buffered
 
-	return c
-}
+	if args.metricsAddr != "" {
+		c.MetricsAddr = args.metricsAddr
+	}
 
-// Check paths to make sure key() syntax is valid
-func (c *Config) validatePaths() *Config {
-	for k := range c.Common {
-		validateOutput(c.Common[k])
+	if args.checkpoint != "" {
+		c.Checkpoint = args.checkpoint
 	}
-	for _, l := range c.Logs {
-		for k := range l.Output {
-			validateOutput(l.Output[k])
-		}
+
+	if args.summary != "" {
+		c.Summary = args.summary
 	}
-	return c
-}
 
-// Helper for validatePaths()
-func validateOutput(o any) {
-	switch o := o.(type) {
-	case string:
-		if _, err := validatePathElements(o); err != nil {
-			logAndDie(err.Error())
-		}
-	case OutputMap:
-		if hasKeys(o, "src", "regex", "value") {
-			s := o["src"].(string)
-			if _, err := validatePathElements(s); err != nil {
-				logAndDie(err.Error())
-			}
-		} else {
-			for k := range o {
-				switch kv := o[k].(type) {
-				case string:
-					if _, err := validatePathElements(kv); err != nil {
-						logAndDie(err.Error())
-					}
-				case OutputMap:
-					validateOutput(kv)
-				}
-			}
+	if args.orderWindow > 0 {
+		if c.Ordering == nil {
+			c.Ordering = &Ordering{}
 		}
+		c.Ordering.Window = args.orderWindow
+	}
+
+	if args.dedup && c.Dedup == nil {
+		c.Dedup = &Dedup{}
 	}
+
+	return c
 }
 
 func logAndDie(msg string) {
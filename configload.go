@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ${NAME} or ${NAME:-default}. $${ is a literal ${.
+var envVarRE = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// The merged config and where each part of it came from.
+type configDoc struct {
+	root  *yaml.Node
+	files map[*yaml.Node]string
+	paths []string // every file read, includes too
+}
+
+// Reads the -config files and merges them in order. Without any, the
+// config comes from stdin like it always has. root is nil if there's no
+// config at all.
+func loadConfig(files []string) (*configDoc, error) {
+	doc := &configDoc{files: map[*yaml.Node]string{}}
+	if len(files) == 0 {
+		data := readFromStdin()
+		if data == nil {
+			return doc, nil
+		}
+		var err error
+		doc.root, err = doc.parse(data, "stdin", ".", nil)
+		return doc, err
+	}
+	for _, f := range files {
+		n, err := doc.readFile(f, nil)
+		if err != nil {
+			return nil, err
+		}
+		doc.root = mergeNodes(doc.root, n)
+	}
+	return doc, nil
+}
+
+// The file a node came from, for error messages.
+func (doc *configDoc) file(n *yaml.Node) string {
+	if file, ok := doc.files[n]; ok {
+		return file
+	}
+	return "config"
+}
+
+func (doc *configDoc) markFile(n *yaml.Node, file string) {
+	doc.files[n] = file
+	for _, c := range n.Content {
+		doc.markFile(c, file)
+	}
+}
+
+// seen is the chain of includes that got us here, to catch cycles.
+func (doc *configDoc) readFile(path string, seen []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(seen, abs) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(seen, abs), " -> "))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc.paths = append(doc.paths, path)
+	return doc.parse(data, path, filepath.Dir(path), append(seen, abs))
+}
+
+func (doc *configDoc) parse(data []byte, name, dir string, seen []string) (*yaml.Node, error) {
+	var top yaml.Node
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(top.Content) == 0 {
+		return nil, nil
+	}
+	root := top.Content[0]
+	expandEnvNodes(root)
+	doc.markFile(root, name)
+	if err := doc.resolveIncludes(root, dir, seen); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return root, nil
+}
+
+// Merges src into dst. Maps are merged key by key. Anything else in src,
+// lists included, replaces what was in dst.
+func mergeNodes(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil {
+		return src
+	}
+	if src == nil {
+		return dst
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		if j := mappingIndex(dst, k.Value); j >= 0 {
+			dst.Content[j+1] = mergeNodes(dst.Content[j+1], v)
+		} else {
+			dst.Content = append(dst.Content, k, v)
+		}
+	}
+	return dst
+}
+
+// Where key is in a mapping node's Content, or -1.
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Swaps ${NAME} and ${NAME:-default} in values for the environment
+// variable. The default is used when NAME is unset or empty.
+func expandEnvNodes(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		expanded := expandEnv(n.Value)
+		if expanded != n.Value {
+			n.Value = expanded
+			if n.Style == 0 {
+				// Let plain values resolve again, so ${LIMIT} can be an int.
+				n.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			expandEnvNodes(n.Content[i])
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			expandEnvNodes(c)
+		}
+	}
+}
+
+func expandEnv(s string) string {
+	return envVarRE.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		sub := envVarRE.FindStringSubmatch(m)
+		if v := os.Getenv(sub[1]); v != "" {
+			return v
+		}
+		return sub[2]
+	})
+}
+
+// Handles include:. At the top of a file the included files are merged in
+// underneath it (the file's own keys win). An item that's only an include
+// in a top-level list, or in a log's output list, is replaced by the
+// included lists, which is how output fragments get shared between logs.
+// Anywhere else include is just a key, so an output can be called that.
+// Paths are relative to the file doing the including.
+func (doc *configDoc) resolveIncludes(root *yaml.Node, dir string, seen []string) error {
+	switch root.Kind {
+	case yaml.SequenceNode:
+		// A file of list items, like output fields.
+		return doc.includeItems(root, dir, seen)
+	case yaml.MappingNode:
+	default:
+		return nil
+	}
+
+	var includes *yaml.Node
+	var content []*yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		if k.Value == "include" {
+			includes = v
+			continue
+		}
+		content = append(content, k, v)
+		if v.Kind != yaml.SequenceNode {
+			continue
+		}
+		if err := doc.includeItems(v, dir, seen); err != nil {
+			return err
+		}
+		if k.Value != "logs" {
+			continue
+		}
+		for _, log := range v.Content {
+			if j := mappingIndex(log, "output"); log.Kind == yaml.MappingNode && j >= 0 {
+				if out := log.Content[j+1]; out.Kind == yaml.SequenceNode {
+					if err := doc.includeItems(out, dir, seen); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	root.Content = content
+	if includes == nil {
+		return nil
+	}
+	included, err := doc.includeNodes(includes, dir, seen)
+	if err != nil {
+		return err
+	}
+	var base *yaml.Node
+	for _, inc := range included {
+		if inc.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: included config has to be a map here", includes.Line)
+		}
+		base = mergeNodes(base, inc)
+	}
+	own := *root
+	*root = *mergeNodes(base, &own)
+	return nil
+}
+
+// Replaces the list's items that are only an include with what they
+// include.
+func (doc *configDoc) includeItems(list *yaml.Node, dir string, seen []string) error {
+	var items []*yaml.Node
+	for _, item := range list.Content {
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 || item.Content[0].Value != "include" {
+			items = append(items, item)
+			continue
+		}
+		included, err := doc.includeNodes(item.Content[1], dir, seen)
+		if err != nil {
+			return err
+		}
+		for _, inc := range included {
+			if inc.Kind == yaml.SequenceNode {
+				items = append(items, inc.Content...)
+			} else {
+				items = append(items, inc)
+			}
+		}
+	}
+	list.Content = items
+	return nil
+}
+
+// include: takes one path or a list of them.
+func (doc *configDoc) includeNodes(spec *yaml.Node, dir string, seen []string) ([]*yaml.Node, error) {
+	var paths []string
+	switch spec.Kind {
+	case yaml.ScalarNode:
+		paths = []string{spec.Value}
+	case yaml.SequenceNode:
+		for _, p := range spec.Content {
+			if p.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: include needs file paths", p.Line)
+			}
+			paths = append(paths, p.Value)
+		}
+	default:
+		return nil, fmt.Errorf("line %d: include needs a file path or a list of them", spec.Line)
+	}
+
+	var result []*yaml.Node
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		n, err := doc.readFile(p, seen)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
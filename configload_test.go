@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func loadTestConfig(t *testing.T, files ...string) *Config {
+	doc, err := loadConfig(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Config
+	if err := doc.root.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestConfigMerge(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+match-rule: drop-no-match
+projects: [one, two]
+dedup:
+  max-entries: 10
+  ttl: 1m
+`,
+		"prod.yaml": `
+projects: [three]
+dedup:
+  max-entries: 20
+`,
+	})
+	c := loadTestConfig(t, filepath.Join(dir, "base.yaml"), filepath.Join(dir, "prod.yaml"))
+
+	if c.MatchRule != "drop-no-match" {
+		t.Errorf("match-rule = %q", c.MatchRule)
+	}
+	if !reflect.DeepEqual(c.Projects, []string{"three"}) {
+		t.Errorf("lists should be replaced, got %v", c.Projects)
+	}
+	if c.Dedup.MaxEntries != 20 || c.Dedup.TTL.String() != "1m0s" {
+		t.Errorf("maps should be merged, got %+v", c.Dedup)
+	}
+}
+
+func TestConfigInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.yaml": `
+include: shared/base.yaml
+match-rule: all
+logs:
+- name: app
+  output:
+  - include: shared/fields.yaml
+  - extra: jsonPayload.extra
+`,
+		"shared/base.yaml": `
+match-rule: drop-no-match
+projects: [proj]
+`,
+		"shared/fields.yaml": `
+- user: jsonPayload.user
+- ip: httpRequest.remoteIp
+`,
+	})
+	c := loadTestConfig(t, filepath.Join(dir, "main.yaml"))
+
+	if c.MatchRule != "all" {
+		t.Errorf("the including file should win, got match-rule %q", c.MatchRule)
+	}
+	if !reflect.DeepEqual(c.Projects, []string{"proj"}) {
+		t.Errorf("projects = %v", c.Projects)
+	}
+	var names []string
+	for _, o := range c.Logs[0].Output {
+		for name := range o {
+			names = append(names, name)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"user", "ip", "extra"}) {
+		t.Errorf("outputs = %v", names)
+	}
+}
+
+func TestConfigIncludeOnlyAtTheTop(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.yaml": `
+projects: [proj]
+logs:
+- name: app
+  output:
+  - request:
+      include: jsonPayload.include
+      method: jsonPayload.method
+`,
+	})
+	c := loadTestConfig(t, filepath.Join(dir, "main.yaml"))
+
+	request, ok := c.Logs[0].Output[0]["request"].(tailor.OutputMap)
+	if !ok || request["include"] != "jsonPayload.include" || request["method"] != "jsonPayload.method" {
+		t.Errorf("outputs = %v; want an output called include", c.Logs[0].Output)
+	}
+}
+
+func TestConfigIncludeCycle(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: b.yaml\n",
+		"b.yaml": "include: a.yaml\n",
+	})
+	_, err := loadConfig([]string{filepath.Join(dir, "a.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("expected an include cycle error, got %v", err)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	t.Setenv("TAILOR_PROJECT", "from-env")
+	t.Setenv("TAILOR_EMPTY", "")
+	dir := writeConfigFiles(t, map[string]string{
+		"env.yaml": `
+projects: ["${TAILOR_PROJECT}", "${TAILOR_EMPTY:-fallback}", "${TAILOR_UNSET}"]
+checkpoint: $${NOT_EXPANDED}
+limit: ${TAILOR_LIMIT:-25}
+`,
+	})
+	c := loadTestConfig(t, filepath.Join(dir, "env.yaml"))
+
+	if !reflect.DeepEqual(c.Projects, []string{"from-env", "fallback", ""}) {
+		t.Errorf("projects = %q", c.Projects)
+	}
+	if c.Checkpoint != "${NOT_EXPANDED}" {
+		t.Errorf("checkpoint = %q", c.Checkpoint)
+	}
+	if c.Limit != 25 {
+		t.Errorf("plain values should resolve after expansion, got limit %d", c.Limit)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+const DefaultDedupMaxEntries int = 100000
+
+// Config for dropping entries we've already seen.
+type Dedup struct {
+	MaxEntries int           `yaml:"max-entries"`
+	TTL        time.Duration `yaml:"ttl"` // 0 means only max-entries bounds it
+}
+
+// An LRU of insertId+logName keys. Safe for use by multiple goroutines.
+type dedupCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	order      *list.List // front is most recent
+	keys       map[string]*list.Element
+}
+
+type dedupItem struct {
+	key  string
+	seen time.Time
+}
+
+func newDedupCache(d *Dedup) *dedupCache {
+	c := &dedupCache{
+		maxEntries: d.MaxEntries,
+		ttl:        d.TTL,
+		order:      list.New(),
+		keys:       make(map[string]*list.Element),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = DefaultDedupMaxEntries
+	}
+	return c
+}
+
+var dedup *dedupCache
+
+// Returns true if we've seen this entry recently.
+func isDuplicate(entry *logpb.LogEntry) bool {
+	if dedup == nil || entry.InsertId == "" {
+		return false
+	}
+	return dedup.seen(entry.InsertId+"\x00"+entry.LogName, time.Now())
+}
+
+// Records the key and reports whether it was already there.
+func (c *dedupCache) seen(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+
+	if el, ok := c.keys[key]; ok {
+		el.Value.(*dedupItem).seen = now
+		c.order.MoveToFront(el)
+		return true
+	}
+
+	c.keys[key] = c.order.PushFront(&dedupItem{key: key, seen: now})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+	return false
+}
+
+// Drops keys older than the TTL. They're at the back.
+func (c *dedupCache) expire(now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	for el := c.order.Back(); el != nil; el = c.order.Back() {
+		if now.Sub(el.Value.(*dedupItem).seen) < c.ttl {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *dedupCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.keys, el.Value.(*dedupItem).key)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newDedupCache(&Dedup{MaxEntries: 2, TTL: time.Minute})
+
+	steps := []struct {
+		key      string
+		at       time.Duration
+		expected bool
+	}{
+		{"a", 0, false},
+		{"a", time.Second, true},
+		{"b", 2 * time.Second, false},
+		{"c", 3 * time.Second, false}, // pushes out "a"
+		{"a", 4 * time.Second, false},
+		{"c", 5 * time.Second, true},
+		{"c", 2 * time.Minute, false}, // expired
+	}
+
+	for _, s := range steps {
+		if got := c.seen(s.key, now.Add(s.at)); got != s.expected {
+			t.Errorf("seen(%q) at +%v = %v; want %v", s.key, s.at, got, s.expected)
+		}
+	}
+}
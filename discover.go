@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+const DiscoverExampleLen int = 60
+
+// The full entry uses a few names that aren't paths. These are the paths.
+var discoverPathNames = map[string]string{
+	"spanid":         "spanId",
+	"tracesampled":   "traceSampled",
+	"sourcelocation": "sourceLocation",
+}
+
+// What we saw at one path.
+type pathInfo struct {
+	count   int
+	types   map[string]int
+	example string
+}
+
+type logDiscovery struct {
+	entries int
+	paths   map[string]*pathInfo
+}
+
+// Collects the paths seen in entries, per log.
+type discovery struct {
+	perLog int
+	logs   map[string]*logDiscovery
+}
+
+func newDiscovery(perLog int) *discovery {
+	return &discovery{perLog: perLog, logs: map[string]*logDiscovery{}}
+}
+
+// The discover command: tails entries and prints the paths found in them.
+func discoverMain(argv []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	var args cmdlnArgs
+	addSourceFlags(fs, &args, 1000)
+	perLog := fs.Int("n", 100, "Entries to look at per log")
+	starter := fs.Bool("starter", false, "Print a starter YAML config instead of the paths")
+	fs.Usage = func() {
+		stderrln("Usage of log-tailor discover:")
+		stderrln("  Tails entries and lists the paths in them with their types,")
+		stderrln("  how often they show up and an example value.\n")
+		stderrln("Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(argv)
+	if args.limit <= 0 {
+		args.limit = 1000
+	}
+
+	config = getConfig(&args)
+	setupDecoding()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go handleSignals(cancel)
+
+	ch := make(chan *logpb.LogEntry, LogEntryChannelBufferSize)
+	pulls := startPulling(ctx, cancel, ch)
+	go func() {
+		pulls.wait()
+		close(ch)
+	}()
+
+	d := newDiscovery(*perLog)
+	for entry := range ch {
+		d.add(entry)
+		if d.done() {
+			cancel()
+		}
+	}
+
+	if *starter {
+		d.printStarter(os.Stdout)
+	} else {
+		d.print(os.Stdout)
+	}
+	os.Exit(shutdownExitCode())
+}
+
+func (d *discovery) add(entry *logpb.LogEntry) {
+	name := tailor.LogName(entry)
+	ld, ok := d.logs[name]
+	if !ok {
+		ld = &logDiscovery{paths: map[string]*pathInfo{}}
+		d.logs[name] = ld
+	}
+	if ld.entries >= d.perLog {
+		return
+	}
+	ld.entries++
+
+	// Walk it the same way it would be output in full.
+	item, _ := tailorer.EntryMap(entry)
+	seen := map[string]bool{}
+	for k, v := range item {
+		if fixed, ok := discoverPathNames[k]; ok {
+			k = fixed
+		}
+		ld.walk(pathKey("", k), tailor.PlainValue(v), seen)
+	}
+}
+
+// True when every log asked for with -l has had its fill.
+func (d *discovery) done() bool {
+	if len(config.Logs) == 0 {
+		return false
+	}
+	for _, l := range config.Logs {
+		if ld, ok := d.logs[l.Name]; !ok || ld.entries < d.perLog {
+			return false
+		}
+	}
+	return true
+}
+
+// Records the path and everything under it. Paths are only counted once
+// per entry, even if a list has them many times.
+func (ld *logDiscovery) walk(path string, v any, seen map[string]bool) {
+	info, ok := ld.paths[path]
+	if !ok {
+		info = &pathInfo{types: map[string]int{}}
+		ld.paths[path] = info
+	}
+	if !seen[path] {
+		seen[path] = true
+		info.count++
+	}
+	info.types[typeName(v)]++
+
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			ld.walk(pathKey(path, k), child, seen)
+		}
+	case tailor.OutputMap:
+		for k, child := range v {
+			ld.walk(pathKey(path, k), child, seen)
+		}
+	case []any:
+		for _, child := range v {
+			ld.walk(path+"[*]", tailor.PlainValue(child), seen)
+		}
+	default:
+		if info.example == "" && v != nil {
+			info.example = tailor.StringValue(v)
+			if len(info.example) > DiscoverExampleLen {
+				info.example = info.example[:DiscoverExampleLen] + "..."
+			}
+		}
+	}
+}
+
+// Adds a key to a path, using key() if it has characters paths care about.
+func pathKey(path, key string) string {
+	if strings.ContainsAny(key, ".[]()*") {
+		key = "key(" + key + ")"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64, float32, int, int32, int64, uint32, uint64:
+		return "number"
+	case map[string]any, tailor.OutputMap:
+		return "object"
+	case []any:
+		return "list"
+	}
+	return "string"
+}
+
+func (d *discovery) sortedLogs() []string {
+	var names []string
+	for name := range d.logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ld *logDiscovery) sortedPaths() []string {
+	var paths []string
+	for p := range ld.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Prints each log's paths with their types, frequency and an example.
+func (d *discovery) print(w io.Writer) {
+	for _, name := range d.sortedLogs() {
+		ld := d.logs[name]
+		fmt.Fprintf(w, "--- %s (%d entries)\n", name, ld.entries)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, p := range ld.sortedPaths() {
+			info := ld.paths[p]
+			pct := 100 * info.count / ld.entries
+			example := ""
+			if info.example != "" {
+				example = fmt.Sprintf("%q", info.example)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d%%\t%s\n", p, info.typeList(), pct, example)
+		}
+		tw.Flush()
+	}
+}
+
+func (info *pathInfo) typeList() string {
+	var types []string
+	for t := range info.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, "|")
+}
+
+// Prints a config with the usual common outputs and, for each log, the
+// payload values that show up in at least half its entries.
+func (d *discovery) printStarter(w io.Writer) {
+	common := []yaml.MapSlice{
+		{{Key: "timestamp", Value: "timestamp"}},
+		{{Key: "severity", Value: "severity"}},
+		{{Key: "log-name", Value: yaml.MapSlice{
+			{Key: "src", Value: "logName"},
+			{Key: "regex", Value: "projects/.*?/logs/(.*)"},
+			{Key: "value", Value: "$1"},
+		}}},
+		{{Key: "resource-type", Value: "resource.type"}},
+	}
+
+	var logs []yaml.MapSlice
+	for _, name := range d.sortedLogs() {
+		ld := d.logs[name]
+		var outputs []yaml.MapSlice
+		used := map[string]bool{}
+		for _, p := range ld.sortedPaths() {
+			info := ld.paths[p]
+			if !isPayloadPath(p) || info.example == "" || info.count*2 < ld.entries {
+				continue
+			}
+			outputs = append(outputs, yaml.MapSlice{{Key: outputName(p, used), Value: p}})
+		}
+		logs = append(logs, yaml.MapSlice{
+			{Key: "name", Value: name},
+			{Key: "output", Value: outputs},
+		})
+	}
+
+	starter := yaml.MapSlice{
+		{Key: "match-rule", Value: "drop-no-match"},
+		{Key: "projects", Value: config.Projects},
+		{Key: "common-output", Value: common},
+		{Key: "logs", Value: logs},
+	}
+	bytes, err := yaml.Marshal(starter)
+	if err != nil {
+		stderrf("%v\n", err)
+		return
+	}
+	w.Write(bytes)
+}
+
+func isPayloadPath(p string) bool {
+	for _, prefix := range []string{"protoPayload", "jsonPayload", "textPayload"} {
+		if p == prefix || strings.HasPrefix(p, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Names an output after the end of its path, backing up a level if the
+// name's taken.
+func outputName(path string, used map[string]bool) string {
+	elems, _ := tailor.SplitPath(strings.ReplaceAll(path, "[*]", ""))
+	name := ""
+	for i := len(elems) - 1; i >= 0; i-- {
+		if name == "" {
+			name = elems[i]
+		} else {
+			name = elems[i] + "-" + name
+		}
+		if !used[name] {
+			break
+		}
+	}
+	used[name] = true
+	return name
+}
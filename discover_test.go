@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestDiscoveryPaths(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config = &Config{}
+
+	d := newDiscovery(2)
+	for _, user := range []any{"a@example.com", nil, "c@example.com"} {
+		payload, err := structpb.NewStruct(map[string]any{
+			"user":   user,
+			"tags":   []any{"x", "y"},
+			"k8s.io": map[string]any{"ok": true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.add(&logpb.LogEntry{
+			LogName: "projects/p/logs/app",
+			Payload: &logpb.LogEntry_JsonPayload{JsonPayload: payload},
+		})
+	}
+
+	ld := d.logs["app"]
+	if ld.entries != 2 {
+		t.Fatalf("entries = %d; want 2 (the -n cap)", ld.entries)
+	}
+
+	user := ld.paths["jsonPayload.user"]
+	if user.count != 2 || user.typeList() != "null|string" || user.example != "a@example.com" {
+		t.Errorf("jsonPayload.user = %+v", user)
+	}
+	if tags := ld.paths["jsonPayload.tags[*]"]; tags == nil || tags.count != 2 {
+		t.Errorf("jsonPayload.tags[*] = %+v", tags)
+	}
+	if _, ok := ld.paths["jsonPayload.key(k8s.io).ok"]; !ok {
+		t.Errorf("keys with dots should use key(): %v", ld.sortedPaths())
+	}
+	if _, ok := ld.paths["spanId"]; !ok {
+		t.Errorf("spanid should be reported as spanId: %v", ld.sortedPaths())
+	}
+
+	var b strings.Builder
+	d.printStarter(&b)
+	if !strings.Contains(b.String(), "- user: jsonPayload.user") {
+		t.Errorf("starter config is missing the user output:\n%s", b.String())
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	used := map[string]bool{}
+	var got []string
+	for _, p := range []string{"protoPayload.a.name", "protoPayload.b.name", "protoPayload.items[*].id"} {
+		got = append(got, outputName(p, used))
+	}
+	expected := []string{"name", "b-name", "id"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("outputName() = %v; want %v", got, expected)
+	}
+}
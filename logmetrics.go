@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+const DefaultMaxSeries int = 1000
+
+// A metric derived from log entries. Declared in the config under metrics:
+type MetricDef struct {
+	Name      string            `yaml:"name"`
+	Type      string            `yaml:"type"` // counter or histogram
+	Help      string            `yaml:"help"`
+	Log       string            `yaml:"log"`    // Only count entries from this log (short name)
+	Labels    map[string]string `yaml:"labels"` // label name -> entry path
+	Value     string            `yaml:"value"`  // entry path, histograms only
+	Buckets   []float64         `yaml:"buckets"`
+	MaxSeries int               `yaml:"max-series"`
+}
+
+type logMetric struct {
+	def        *MetricDef
+	labelNames []string
+	counter    *prometheus.CounterVec
+	histogram  *prometheus.HistogramVec
+
+	mu     sync.Mutex
+	series map[string]bool
+}
+
+var logMetrics []*logMetric
+
+var seriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "log_tailor_metric_series_dropped_total",
+	Help: "Observations dropped because a log metric hit its max-series limit.",
+}, []string{"metric"})
+
+func init() {
+	metricsRegistry.MustRegister(seriesDropped)
+}
+
+// Creates and registers the config-declared metrics.
+func setupLogMetrics(defs []MetricDef) error {
+	for i := range defs {
+		def := &defs[i]
+		m := &logMetric{def: def, series: make(map[string]bool)}
+		if def.MaxSeries <= 0 {
+			def.MaxSeries = DefaultMaxSeries
+		}
+		if def.Help == "" {
+			def.Help = "Derived from log entries by log-tailor."
+		}
+		for name := range def.Labels {
+			m.labelNames = append(m.labelNames, name)
+		}
+		sort.Strings(m.labelNames)
+
+		var c prometheus.Collector
+		switch def.Type {
+		case "counter":
+			m.counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: def.Name,
+				Help: def.Help,
+			}, m.labelNames)
+			c = m.counter
+		case "histogram":
+			if def.Value == "" {
+				return fmt.Errorf("metric %s: histograms need a value path", def.Name)
+			}
+			buckets := def.Buckets
+			if len(buckets) == 0 {
+				buckets = prometheus.DefBuckets
+			}
+			m.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    def.Name,
+				Help:    def.Help,
+				Buckets: buckets,
+			}, m.labelNames)
+			c = m.histogram
+		default:
+			return fmt.Errorf("metric %s: unknown type %q (counter or histogram)", def.Name, def.Type)
+		}
+
+		if err := metricsRegistry.Register(c); err != nil {
+			return fmt.Errorf("metric %s: %v", def.Name, err)
+		}
+		logMetrics = append(logMetrics, m)
+	}
+	return nil
+}
+
+// Updates all the log metrics that apply to this entry.
+func observeLogMetrics(entry *logpb.LogEntry) {
+	for _, m := range logMetrics {
+		m.observe(entry)
+	}
+}
+
+func (m *logMetric) observe(entry *logpb.LogEntry) {
+	if m.def.Log != "" && m.def.Log != tailor.LogName(entry) {
+		return
+	}
+
+	values := make([]string, len(m.labelNames))
+	for i, name := range m.labelNames {
+		v, _ := tailorer.Value(entry, m.def.Labels[name])
+		values[i] = tailor.StringValue(v)
+	}
+	if !m.admit(values) {
+		seriesDropped.WithLabelValues(m.def.Name).Inc()
+		return
+	}
+
+	if m.counter != nil {
+		m.counter.WithLabelValues(values...).Inc()
+		return
+	}
+	v, err := tailorer.Value(entry, m.def.Value)
+	if err != nil {
+		return
+	}
+	if f, ok := tailor.NumericValue(v); ok {
+		m.histogram.WithLabelValues(values...).Observe(f)
+	}
+}
+
+// Keeps the number of label combinations at or under max-series.
+func (m *logMetric) admit(values []string) bool {
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.series[key] {
+		return true
+	}
+	if len(m.series) >= m.def.MaxSeries {
+		return false
+	}
+	m.series[key] = true
+	return true
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+func TestLogMetricMaxSeries(t *testing.T) {
+	m := &logMetric{
+		def:    &MetricDef{Name: "test", MaxSeries: 2},
+		series: make(map[string]bool),
+	}
+	admits := []struct {
+		values   []string
+		expected bool
+	}{
+		{[]string{"a"}, true},
+		{[]string{"b"}, true},
+		{[]string{"a"}, true},
+		{[]string{"c"}, false},
+	}
+	for _, a := range admits {
+		if got := m.admit(a.values); got != a.expected {
+			t.Errorf("admit(%v) = %v; want %v", a.values, got, a.expected)
+		}
+	}
+}
+
+func metricEntry(log, project string, payload map[string]any) *logpb.LogEntry {
+	s, _ := structpb.NewStruct(payload)
+	return &logpb.LogEntry{
+		LogName:  "projects/" + project + "/logs/" + log,
+		Resource: &mrpb.MonitoredResource{Labels: map[string]string{"project_id": project}},
+		Payload:  &logpb.LogEntry_JsonPayload{JsonPayload: s},
+	}
+}
+
+// What the registry has for the metric: each series (its label values,
+// joined with commas) and its count, or for histograms its sum and count.
+func gatherLogMetric(t *testing.T, name string) map[string]float64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			var values []string
+			for _, l := range m.GetLabel() {
+				values = append(values, l.GetValue())
+			}
+			series := strings.Join(values, ",")
+			if h := m.GetHistogram(); h != nil {
+				got[series+" sum"] = h.GetSampleSum()
+				got[series+" count"] = float64(h.GetSampleCount())
+			} else {
+				got[series] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return got
+}
+
+func TestObserveLogMetrics(t *testing.T) {
+	savedTailor, savedMetrics := tailorer, logMetrics
+	defer func() { tailorer, logMetrics = savedTailor, savedMetrics }()
+	tailorer, _ = newTailor(&tailor.Config{})
+
+	tests := []struct {
+		name     string
+		def      MetricDef
+		entries  []*logpb.LogEntry
+		expected map[string]float64
+	}{
+		{
+			name: "labels from paths",
+			def: MetricDef{Type: "counter", Labels: map[string]string{
+				"project": "resource.labels.project_id",
+				"user":    "jsonPayload.user",
+			}},
+			entries: []*logpb.LogEntry{
+				metricEntry("app", "p1", map[string]any{"user": "alice"}),
+				metricEntry("app", "p1", map[string]any{"user": "alice"}),
+				metricEntry("app", "p2", map[string]any{"user": "bob"}),
+				metricEntry("app", "p2", nil),
+			},
+			expected: map[string]float64{"p1,alice": 2, "p2,bob": 1, "p2,": 1},
+		},
+		{
+			name: "only its log",
+			def:  MetricDef{Type: "counter", Log: "audit"},
+			entries: []*logpb.LogEntry{
+				metricEntry("app", "p1", nil),
+				metricEntry("audit", "p1", nil),
+				metricEntry("audit", "p1", nil),
+			},
+			expected: map[string]float64{"": 2},
+		},
+		{
+			name: "histogram value",
+			def: MetricDef{
+				Type:    "histogram",
+				Labels:  map[string]string{"user": "jsonPayload.user"},
+				Value:   "jsonPayload.latency",
+				Buckets: []float64{1, 10},
+			},
+			entries: []*logpb.LogEntry{
+				metricEntry("app", "p1", map[string]any{"user": "alice", "latency": 0.5}),
+				metricEntry("app", "p1", map[string]any{"user": "alice", "latency": 2.5}),
+				metricEntry("app", "p1", map[string]any{"user": "alice", "latency": "slow"}),
+				metricEntry("app", "p1", map[string]any{"user": "bob"}),
+			},
+			expected: map[string]float64{"alice sum": 3, "alice count": 2},
+		},
+	}
+	for i, tt := range tests {
+		tt.def.Name = fmt.Sprintf("log_metric_test_%d", i)
+		logMetrics = nil
+		if err := setupLogMetrics([]MetricDef{tt.def}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		m := logMetrics[0]
+		for _, entry := range tt.entries {
+			observeLogMetrics(entry)
+		}
+
+		got := gatherLogMetric(t, tt.def.Name)
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: series = %v; want %v", tt.name, got, tt.expected)
+		}
+		for series, expected := range tt.expected {
+			if got[series] != expected {
+				t.Errorf("%s: %q = %v; want %v", tt.name, series, got[series], expected)
+			}
+		}
+		if m.counter != nil {
+			metricsRegistry.Unregister(m.counter)
+		} else {
+			metricsRegistry.Unregister(m.histogram)
+		}
+	}
+}
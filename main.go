@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	logger "log"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
@@ -16,51 +17,247 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	// To get the proto defs
-	_ "google.golang.org/genproto/googleapis/cloud/audit"
-	_ "google.golang.org/genproto/googleapis/iam/v1/logging"
+	"github.com/zonkhead/log-tailor/tailor"
 )
 
 var config *Config
 
+// What entries get tailored with, sampled and rate limited by, written
+// to and checked for alerts by. They're swapped along with the config on a
+// reload.
+var tailorer *tailor.Tailor
+var limiter *tailor.Limiter
+var sink tailor.Sink
+var alerter *tailor.Alerter
+var alertSink tailor.Sink // nil if there are no alert rules
+
+// What rolls entries up with aggregate: or groups them with traces:, or
+// nil. It's made at startup and kept over reloads.
+var collector tailor.Collector
+
+// Workers hold it for reading while they process an entry. A reload holds
+// it for writing while it swaps the config.
+var configMU sync.RWMutex
+
+// For code that reads the config outside a worker.
+func currentConfig() *Config {
+	configMU.RLock()
+	defer configMU.RUnlock()
+	return config
+}
+
 const LogEntryChannelBufferSize int = 1024
 
 func main() {
-	stdin := readFromStdin()
-	config = getConfig(stdin, parseArgs())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "discover":
+			discoverMain(os.Args[2:])
+			return
+		case "validate":
+			validateMain(os.Args[2:])
+			return
+		}
+	}
+
+	args := parseArgs()
+	config = getConfig(args)
 
 	ctx, cancel := context.WithCancel(context.Background())
+	go handleSignals(cancel)
+
 	ch := make(chan *logpb.LogEntry, LogEntryChannelBufferSize)
 
-	var pullWG sync.WaitGroup
+	if err := setupLogMetrics(config.Metrics); err != nil {
+		logAndDie(err.Error())
+	}
+	if len(config.Metrics) > 0 && config.MetricsAddr == "" {
+		logger.Println("Log metrics are configured but there's no metrics-addr to serve them on.")
+	}
+	startMetricsServer(config.MetricsAddr, ch)
 
-	for _, p := range config.Projects {
-		go pullLogs(ctx, cancel, &pullWG, p, ch)
+	if config.Dedup != nil {
+		dedup = newDedupCache(config.Dedup)
 	}
 
+	setupDecoding()
+	limiter = tailor.NewLimiter(tailorer)
+	collector = tailor.NewCollector(tailorer)
+	alerter = tailor.NewAlerter(tailorer)
+	var err error
+	if sink, err = openSink(config, tailorer); err != nil {
+		logAndDie(err.Error())
+	}
+	if alertSink, err = openAlertSink(config); err != nil {
+		logAndDie(err.Error())
+	}
+	alertsDone := make(chan struct{})
+	go writeQueuedAlerts(alertsDone)
+	stopFlusher, flusherDone := make(chan struct{}), make(chan struct{})
+	go runSinkFlusher(stopFlusher, flusherDone)
+	pulls := startPulling(ctx, cancel, ch)
+	watchConfig(ctx, args, pulls)
+
 	numWorkers := 3 * runtime.NumCPU() // 3. Love it or leave it.
+	procCh := ch
+
+	if config.Ordering != nil {
+		// Sorting is pointless if a bunch of workers shuffle it again.
+		procCh = make(chan *logpb.LogEntry, LogEntryChannelBufferSize)
+		go orderEntries(ch, procCh, config.Ordering)
+		numWorkers = 1
+	}
+
+	stopCollector, collectorDone := make(chan struct{}), make(chan struct{})
+	if collector != nil {
+		go runCollector(stopCollector, collectorDone)
+	} else {
+		close(collectorDone)
+	}
+
 	var procWG sync.WaitGroup
 
 	for i := 0; i < numWorkers; i++ {
-		go processLogEntries(&procWG, ch)
+		procWG.Add(1)
+		go processLogEntries(&procWG, procCh)
 	}
 
-	pullWG.Wait()
+	pulls.wait()
 	close(ch)
 
+	// Everything in the channel gets written before we go.
 	procWG.Wait()
+	close(stopCollector)
+	<-collectorDone
+	close(alertQueue)
+	<-alertsDone
+	close(stopFlusher)
+	<-flusherDone
+	flushMU.Lock()
+	configMU.Lock()
+	closeSink(sink, sinkName(config))
+	if alertSink != nil {
+		if err := alertSink.Close(); err != nil {
+			logger.Printf("Error closing the alert sink: %v", err)
+		}
+	}
+	configMU.Unlock()
+	flushMU.Unlock()
+	os.Stdout.Sync()
+	writeCheckpoint()
+	writeSummary(currentConfig().Summary)
+	stopMetricsServer()
+
+	os.Exit(shutdownExitCode())
 }
 
-// Pulls log entries from cloud loggging and then puts them in the channel.
-func pullLogs(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, projID string, ch chan<- *logpb.LogEntry) {
-	wg.Add(1)
-	defer wg.Done()
+// The Tailor for the config, for anything that reads entries.
+func setupDecoding() {
+	var err error
+	if tailorer, err = newTailor(&config.Config); err != nil {
+		logAndDie(err.Error())
+	}
+}
+
+// Problems decoding payloads are logged and counted, never put in the
+// output.
+func newTailor(c *tailor.Config) (*tailor.Tailor, error) {
+	t, err := tailor.New(c)
+	if err != nil {
+		return nil, err
+	}
+	t.OnError = func(err error) {
+		var de *tailor.DecodeError
+		if errors.As(err, &de) {
+			noteDecodeFailure()
+		}
+		logger.Printf("Error: %v", err)
+	}
+	return t, nil
+}
 
+// The pullers for every project. A reload can restart them when what
+// we're tailing changes.
+type streams struct {
+	ctx    context.Context
+	cancel context.CancelFunc // stops everything, for -limit
+	ch     chan<- *logpb.LogEntry
+
+	mu   sync.Mutex
+	stop context.CancelFunc // stops just this set of pullers
+	wg   *sync.WaitGroup
+}
+
+// Starts pulling from every project. Call wait before closing ch.
+func startPulling(ctx context.Context, cancel context.CancelFunc, ch chan<- *logpb.LogEntry) *streams {
+	s := &streams{ctx: ctx, cancel: cancel, ch: ch}
+	s.stop, s.wg = s.start()
+	return s
+}
+
+func (s *streams) start() (context.CancelFunc, *sync.WaitGroup) {
+	var pullWG sync.WaitGroup
+	ctx, stop := context.WithCancel(s.ctx)
+
+	for _, p := range currentConfig().Projects {
+		pullWG.Add(1)
+		src := &projectSource{projID: p, cancel: s.cancel}
+		go func() {
+			defer pullWG.Done()
+			src.Run(ctx, s.ch)
+		}()
+	}
+	return stop, &pullWG
+}
+
+// Starts new pullers with the current config and then stops the old ones.
+// They overlap a little, so nothing goes by unseen (-dedup drops the
+// doubles).
+func (s *streams) restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return
+	}
+	oldStop, oldWG := s.stop, s.wg
+	s.stop, s.wg = s.start()
+	oldStop()
+	oldWG.Wait()
+}
+
+// Waits for the pullers to finish, including any started by a restart.
+func (s *streams) wait() {
+	for {
+		s.mu.Lock()
+		wg := s.wg
+		s.mu.Unlock()
+
+		wg.Wait()
+
+		s.mu.Lock()
+		done := wg == s.wg
+		s.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// Tails one project. Entries past -limit aren't sent and hitting it calls
+// cancel.
+type projectSource struct {
+	projID string
+	cancel context.CancelFunc
+}
+
+// Pulls log entries from cloud loggging and then puts them in the channel.
+func (s *projectSource) Run(ctx context.Context, ch chan<- *logpb.LogEntry) error {
+	projID := s.projID
 	stream, client := startTailing(ctx, projID)
 
 	for {
 		if stream == nil {
-			return
+			return nil
 		}
 
 		resp, err := stream.Recv()
@@ -69,7 +266,7 @@ func pullLogs(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup
 			logger.Printf("EOF: %s\n", projID)
 			break
 		}
-		if errors.Is(err, context.Canceled) {
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
 			break
 		}
 
@@ -78,22 +275,40 @@ func pullLogs(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup
 			client.Close()
 			if isReconnectableGRPCError(err) {
 				logger.Printf("Cloud Logging disconnected us (%s). Reconnecting...", projID)
+				noteReconnect(projID)
 				stream, client = startTailing(ctx, projID)
 				continue
 			}
 			logger.Printf("Error receiving (%s):%T: %v. Disconnecting...", projID, err, err)
-			return
+			return err
 		}
 
 		for _, entry := range resp.Entries {
-			if !putEntryIntoChannel(entry, ch, cancel) {
+			if !s.admit(entry, ch) {
 				stream.CloseSend()
-				return
+				return nil
 			}
 		}
 	}
 
 	stream.CloseSend()
+	return nil
+}
+
+// Sends the entry on unless it's a duplicate or past -limit. Only what's
+// sent is counted as received and checkpointed. False once there's no
+// room for more.
+func (s *projectSource) admit(entry *logpb.LogEntry, ch chan<- *logpb.LogEntry) bool {
+	if isDuplicate(entry) {
+		noteSuppressed("duplicate")
+		return true
+	}
+	sent, more := putEntryIntoChannel(entry, ch, s.cancel)
+	if sent {
+		noteReceived(s.projID, entry)
+		recordCheckpoint(s.projID, entry)
+	}
+	return more
 }
 
 type TailClient logpb.LoggingServiceV2_TailLogEntriesClient
@@ -146,34 +361,37 @@ func isReconnectableGRPCError(err error) bool {
 var pullCount int = 0
 var pcMU sync.Mutex
 
-// Puts an entry into the channel unless we hit the limit. Hitting the limit returns false
-// and cancels the channel if we hit the limit with this particular request.
-func putEntryIntoChannel(entry *logpb.LogEntry, ch chan<- *logpb.LogEntry, cancel context.CancelFunc) bool {
+// Puts an entry into the channel unless we hit the limit. Says whether it
+// was sent and whether there's room for more. The entry that hits the limit
+// is sent and cancels everything.
+func putEntryIntoChannel(entry *logpb.LogEntry, ch chan<- *logpb.LogEntry, cancel context.CancelFunc) (sent, more bool) {
+	limit := currentConfig().Limit
 	pcMU.Lock()
 	defer pcMU.Unlock()
-	if pullCount >= config.Limit {
-		return false
+	if pullCount >= limit {
+		return false, false
 	}
 
 	ch <- entry
 	pullCount++
 
-	if pullCount == config.Limit {
+	if pullCount == limit {
 		// We hit the limit. Make everyone un-block and go home.
 		cancel()
-		return false
+		return true, false
 	}
 
-	return true
+	return true, true
 }
 
 // Gets all the filters and logs and turns them into a string
 func createFilter(proj string) string {
+	c := currentConfig()
 	var b strings.Builder
-	b.WriteString(createLogsFilter(proj))
-	filtersLen := len(config.Filters)
+	b.WriteString(createLogsFilter(proj, c.Logs))
+	filtersLen := len(c.Filters)
 	if filtersLen > 0 {
-		for _, f := range config.Filters {
+		for _, f := range c.Filters {
 			b.WriteString(" ")
 			b.WriteString(f)
 		}
@@ -181,10 +399,10 @@ func createFilter(proj string) string {
 	return b.String()
 }
 
-func createLogsFilter(proj string) string {
+func createLogsFilter(proj string, configLogs []tailor.Log) string {
 	var b strings.Builder
-	if len(config.Logs) > 0 {
-		logs := logsToSet(config.Logs)
+	if len(configLogs) > 0 {
+		logs := logsToSet(configLogs)
 		b.WriteString("logName = (")
 		for i, log := range logs {
 			b.WriteString(`"` + toFQLogStr(proj, escLogName(log)) + `"`)
@@ -197,8 +415,8 @@ func createLogsFilter(proj string) string {
 	return b.String()
 }
 
-func logsToSet(logs []Log) []string {
-	set := make(map[string]*Log)
+func logsToSet(logs []tailor.Log) []string {
+	set := make(map[string]*tailor.Log)
 	for _, log := range logs {
 		set[log.Name] = &log
 	}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	logger "log"
+	"net"
+	"net/http"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics about log-tailor itself. They live in their own registry so the
+// /metrics endpoint only has what we put there (plus the go/process basics).
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	entriesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_tailor_entries_received_total",
+		Help: "Log entries received from Cloud Logging and taken in (not duplicates or past -limit).",
+	}, []string{"project"})
+
+	entriesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "log_tailor_entries_dropped_total",
+		Help: "Log entries dropped by the match rule.",
+	})
+
+	entriesSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_tailor_entries_suppressed_total",
+		Help: "Log entries that were pulled but deliberately not output.",
+	}, []string{"reason"})
+
+	lateEntries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "log_tailor_late_entries_total",
+		Help: "Entries that arrived too late to be put in timestamp order.",
+	})
+
+	reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_tailor_reconnects_total",
+		Help: "Tail stream reconnects after a reconnectable gRPC error.",
+	}, []string{"project"})
+
+	sinkWriteSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "log_tailor_sink_write_seconds",
+		Help:    "Time spent writing one record to a sink.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"sink"})
+
+	sinkWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_tailor_sink_write_errors_total",
+		Help: "Records that failed to be written to a sink.",
+	}, []string{"sink"})
+
+	protoDecodeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "log_tailor_proto_decode_failures_total",
+		Help: "protoPayloads that could not be decoded.",
+	})
+
+	alertsFired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "log_tailor_alerts_total",
+		Help: "Alerts fired by alert rules.",
+	}, []string{"rule"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		entriesReceived,
+		entriesDropped,
+		entriesSuppressed,
+		lateEntries,
+		reconnects,
+		sinkWriteSeconds,
+		sinkWriteErrors,
+		protoDecodeFailures,
+		alertsFired,
+	)
+}
+
+// The /metrics listener, or nil if there's no metrics-addr.
+var metricsServer *http.Server
+
+// Starts the HTTP listener for /metrics if an address was configured. The
+// channel is only used to report how full the entry buffer is.
+func startMetricsServer(addr string, ch chan *logpb.LogEntry) {
+	if addr == "" {
+		return
+	}
+
+	metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "log_tailor_channel_depth",
+		Help: "Log entries waiting in the channel to be processed.",
+	}, func() float64 {
+		return float64(len(ch))
+	}))
+	metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "log_tailor_channel_capacity",
+		Help: "Size of the log entry channel buffer.",
+	}, func() float64 {
+		return float64(cap(ch))
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Printf("Metrics listener on %s failed: %v", addr, err)
+		return
+	}
+	// The address it really got, for :0.
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	metricsServer = srv
+
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("Metrics listener on %s failed: %v", addr, err)
+		}
+	}()
+}
+
+// Stops the /metrics listener, letting scrapes that are going finish.
+func stopMetricsServer() {
+	if metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		logger.Printf("Error stopping the metrics listener: %v", err)
+	}
+}
+
+func observeSinkWrite(sink string, d time.Duration, err error) {
+	sinkWriteSeconds.WithLabelValues(sink).Observe(d.Seconds())
+	if err != nil {
+		sinkWriteErrors.WithLabelValues(sink).Inc()
+	}
+}
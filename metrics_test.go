@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsServer(t *testing.T) {
+	ch := make(chan *logpb.LogEntry, 4)
+	ch <- &logpb.LogEntry{}
+	startMetricsServer("127.0.0.1:0", ch)
+	if metricsServer == nil {
+		t.Fatal("no metrics server")
+	}
+	defer func() { metricsServer = nil }()
+
+	noteReceived("metrics-test", &logpb.LogEntry{LogName: "projects/metrics-test/logs/app"})
+	noteSinkWrite("metrics-test", 0, 0, errors.New("broken pipe"))
+
+	resp, err := http.Get("http://" + metricsServer.Addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, line := range []string{
+		`log_tailor_entries_received_total{project="metrics-test"} 1`,
+		`log_tailor_sink_write_errors_total{sink="metrics-test"} 1`,
+		`log_tailor_channel_depth 1`,
+		`log_tailor_channel_capacity 4`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("/metrics doesn't have %s", line)
+		}
+	}
+
+	stopMetricsServer()
+	if _, err := http.Get("http://" + metricsServer.Addr + "/metrics"); err == nil {
+		t.Errorf("the listener is still up after stopMetricsServer")
+	}
+}
+
+func TestObserveSinkWrite(t *testing.T) {
+	before := testutil.ToFloat64(sinkWriteErrors.WithLabelValues("observe-test"))
+	observeSinkWrite("observe-test", 0, nil)
+	observeSinkWrite("observe-test", 0, errors.New("nope"))
+	if got := testutil.ToFloat64(sinkWriteErrors.WithLabelValues("observe-test")) - before; got != 1 {
+		t.Errorf("%v write errors; want 1", got)
+	}
+	if n := testutil.CollectAndCount(sinkWriteSeconds, "log_tailor_sink_write_seconds"); n == 0 {
+		t.Errorf("no write times observed")
+	}
+}
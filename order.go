@@ -0,0 +1,151 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+const DefaultOrderMaxBuffer int = 10000
+
+// Config for emitting entries in timestamp order.
+type Ordering struct {
+	// How late an entry can be and still be put in order.
+	Window    time.Duration `yaml:"window"`
+	MaxBuffer int           `yaml:"max-buffer"`
+}
+
+// Reads entries from in and writes them to out sorted by timestamp (then
+// insertId). An entry is held until the watermark (the latest timestamp
+// seen minus the window) passes it, or until nothing has come in for the
+// window. The watermark only goes by entry timestamps, so it doesn't
+// matter how far behind Cloud Logging is delivering. Entries that show up
+// behind the watermark are counted as late and passed along right away.
+// Closes out when in is closed.
+func orderEntries(in <-chan *logpb.LogEntry, out chan<- *logpb.LogEntry, ord *Ordering) {
+	o := newOrderer(ord)
+
+	tick := max(ord.Window/4, 100*time.Millisecond)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry, ok := <-in:
+			if !ok {
+				for _, e := range o.flush() {
+					out <- e
+				}
+				close(out)
+				return
+			}
+			for _, e := range o.push(entry, time.Now()) {
+				out <- e
+			}
+		case now := <-ticker.C:
+			for _, e := range o.advance(now) {
+				out <- e
+			}
+		}
+	}
+}
+
+type orderer struct {
+	window    time.Duration
+	maxBuffer int
+	entries   entryHeap
+	maxSeen   time.Time
+	watermark time.Time
+	lastPush  time.Time // wall clock, for flushing when it's quiet
+}
+
+func newOrderer(ord *Ordering) *orderer {
+	o := &orderer{window: ord.Window, maxBuffer: ord.MaxBuffer}
+	if o.maxBuffer <= 0 {
+		o.maxBuffer = DefaultOrderMaxBuffer
+	}
+	return o
+}
+
+// Adds an entry and returns whatever is now ready to go.
+func (o *orderer) push(entry *logpb.LogEntry, now time.Time) []*logpb.LogEntry {
+	o.lastPush = now
+	ts := entry.Timestamp.AsTime()
+	if ts.Before(o.watermark) {
+		noteLate()
+		return append([]*logpb.LogEntry{entry}, o.advance(now)...)
+	}
+	if ts.After(o.maxSeen) {
+		o.maxSeen = ts
+	}
+	heap.Push(&o.entries, entry)
+
+	ready := o.advance(now)
+	// Don't let the buffer grow without bound. The oldest go first.
+	for o.entries.Len() > o.maxBuffer {
+		ready = append(ready, o.pop())
+	}
+	return ready
+}
+
+// Moves the watermark up and returns the entries it passed. If nothing has
+// come in for the window, it moves up to the latest entry so the stragglers
+// don't wait for the next one.
+func (o *orderer) advance(now time.Time) []*logpb.LogEntry {
+	wm := o.maxSeen.Add(-o.window)
+	if now.Sub(o.lastPush) >= o.window {
+		wm = o.maxSeen
+	}
+	if wm.After(o.watermark) {
+		o.watermark = wm
+	}
+
+	var ready []*logpb.LogEntry
+	for o.entries.Len() > 0 && !o.entries[0].Timestamp.AsTime().After(o.watermark) {
+		ready = append(ready, o.pop())
+	}
+	return ready
+}
+
+// Returns everything that's left, in order.
+func (o *orderer) flush() []*logpb.LogEntry {
+	var ready []*logpb.LogEntry
+	for o.entries.Len() > 0 {
+		ready = append(ready, o.pop())
+	}
+	return ready
+}
+
+func (o *orderer) pop() *logpb.LogEntry {
+	e := heap.Pop(&o.entries).(*logpb.LogEntry)
+	if ts := e.Timestamp.AsTime(); ts.After(o.watermark) {
+		o.watermark = ts
+	}
+	return e
+}
+
+// For container/heap
+type entryHeap []*logpb.LogEntry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	ti, tj := h[i].Timestamp.AsTime(), h[j].Timestamp.AsTime()
+	if ti.Equal(tj) {
+		return h[i].InsertId < h[j].InsertId
+	}
+	return ti.Before(tj)
+}
+
+func (h entryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *entryHeap) Push(x any) { *h = append(*h, x.(*logpb.LogEntry)) }
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testEntry(id string, ts time.Time) *logpb.LogEntry {
+	return &logpb.LogEntry{InsertId: id, Timestamp: timestamppb.New(ts)}
+}
+
+func ids(entries []*logpb.LogEntry) []string {
+	var result []string
+	for _, e := range entries {
+		result = append(result, e.InsertId)
+	}
+	return result
+}
+
+func TestOrdererSortsWithinWindow(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := newOrderer(&Ordering{Window: 10 * time.Second})
+
+	var out []*logpb.LogEntry
+	out = append(out, o.push(testEntry("c", base.Add(3*time.Second)), base)...)
+	out = append(out, o.push(testEntry("a", base.Add(1*time.Second)), base)...)
+	out = append(out, o.push(testEntry("b2", base.Add(2*time.Second)), base)...)
+	out = append(out, o.push(testEntry("b1", base.Add(2*time.Second)), base)...)
+	if len(out) != 0 {
+		t.Fatalf("entries inside the window were emitted early: %v", ids(out))
+	}
+
+	out = o.push(testEntry("d", base.Add(12500*time.Millisecond)), base.Add(time.Second))
+	expected := []string{"a", "b1", "b2"}
+	if !reflect.DeepEqual(ids(out), expected) {
+		t.Errorf("push() = %v; want %v", ids(out), expected)
+	}
+
+	if out = o.advance(base.Add(5 * time.Second)); len(out) != 0 {
+		t.Errorf("advance() before the stream went quiet = %v", ids(out))
+	}
+	out = o.advance(base.Add(11 * time.Second))
+	if !reflect.DeepEqual(ids(out), []string{"c", "d"}) {
+		t.Errorf("advance() after the stream went quiet = %v; want [c d]", ids(out))
+	}
+}
+
+// Cloud Logging can deliver entries well behind the clock. That's not
+// late as long as they come in order within the window.
+func TestOrdererDelayedDelivery(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := base.Add(time.Minute)
+	o := newOrderer(&Ordering{Window: 5 * time.Second})
+
+	var out []*logpb.LogEntry
+	for i, e := range []*logpb.LogEntry{
+		testEntry("b", base.Add(2*time.Second)),
+		testEntry("a", base.Add(1*time.Second)),
+		testEntry("d", base.Add(4*time.Second)),
+		testEntry("c", base.Add(3*time.Second)),
+		testEntry("e", base.Add(9*time.Second)),
+	} {
+		out = append(out, o.push(e, now.Add(time.Duration(i)*time.Second))...)
+	}
+	out = append(out, o.flush()...)
+	if !reflect.DeepEqual(ids(out), []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("delayed entries came out as %v", ids(out))
+	}
+}
+
+func TestOrdererLateAndOverflow(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	o := newOrderer(&Ordering{Window: time.Minute, MaxBuffer: 2})
+
+	o.push(testEntry("b", base.Add(2*time.Second)), base)
+	o.push(testEntry("c", base.Add(3*time.Second)), base)
+	out := o.push(testEntry("d", base.Add(4*time.Second)), base)
+	if !reflect.DeepEqual(ids(out), []string{"b"}) {
+		t.Errorf("overflow emitted %v; want [b]", ids(out))
+	}
+
+	// "a" is behind what was already written, so it goes straight out.
+	out = o.push(testEntry("a", base.Add(1*time.Second)), base)
+	if !reflect.DeepEqual(ids(out), []string{"a"}) {
+		t.Errorf("late entry emitted %v; want [a]", ids(out))
+	}
+}
@@ -1,38 +1,19 @@
 package main
 
 import (
-	"bufio"
-	"encoding/csv"
-	"encoding/json"
-	"fmt"
+	"errors"
 	logger "log"
-	"net/url"
 	"os"
-	"reflect"
-	"regexp"
-	"strings"
 	"sync"
 	"time"
 
-	"gopkg.in/yaml.v2"
-
 	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
 
-	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
-	"google.golang.org/protobuf/types/dynamicpb"
-	"google.golang.org/protobuf/types/known/timestamppb"
-
-	// To get the proto defs
-	_ "google.golang.org/genproto/googleapis/cloud/audit"
-	_ "google.golang.org/genproto/googleapis/iam/v1/logging"
+	"github.com/zonkhead/log-tailor/tailor"
 )
 
 // Pulls log entries from the channel and prints them to stdout.
 func processLogEntries(wg *sync.WaitGroup, ch <-chan *logpb.LogEntry) {
-	wg.Add(1)
 	defer wg.Done()
 
 	for {
@@ -41,334 +22,218 @@ func processLogEntries(wg *sync.WaitGroup, ch <-chan *logpb.LogEntry) {
 		if !ok {
 			break
 		}
-		if shouldDropEntry(entry) {
-			continue
-		}
-
-		li, match := createLogItem(entry)
-
-		writer := bufio.NewWriter(os.Stdout)
-
-		switch config.Format {
-		case "yaml":
-			processYAML(writer, li, match)
-		case "jsonl":
-			processJSON(writer, li)
-		case "csv":
-			processCSV(writer, li)
-		}
-
-		writer.Flush()
-
-		if !config.Buffered {
-			os.Stdout.Sync()
-		}
+		// A reload waits until we're done with this one.
+		configMU.RLock()
+		processLogEntry(entry)
+		configMU.RUnlock()
 	}
 }
 
-func processYAML(writer *bufio.Writer, li OutputMap, match *Log) {
-	var logItem any = li
-
-	if len(config.Common) > 0 || (match != nil && len(match.Output) > 0) {
-		logItem = sortedYaml(li, match)
+func processLogEntry(entry *logpb.LogEntry) {
+	if !tailorer.Match(entry) {
+		noteDropped(config.MatchRule)
+		return
 	}
-	if bytes, err := yaml.Marshal(logItem); err != nil {
-		stderrf("%v\n", err)
-	} else {
-		fmt.Fprintf(writer, "---\n%s", bytes)
+	observeLogMetrics(entry)
+	// Like the metrics, alerts see everything that matches.
+	writeAlerts(alerter.Check(entry))
+	if ok, reason := limiter.Allow(entry); !ok {
+		noteSuppressed(reason)
+		return
 	}
-}
 
-func processJSON(writer *bufio.Writer, li OutputMap) {
-	if bytes, err := json.Marshal(li); err != nil {
-		stderrf("%v\n", err)
-	} else {
-		fmt.Fprintf(writer, "%s\n", bytes)
-	}
-}
-
-func processCSV(writer *bufio.Writer, li OutputMap) {
-	var row []string
-	if len(config.Common) > 0 {
-		row = addOutputToRow(config.Common, li, row)
-	}
-	if len(config.Logs) > 0 {
-		for _, l := range config.Logs {
-			row = addOutputToRow(l.Output, li, row)
+	if collector != nil {
+		if ok, reason := collector.Add(entry); !ok {
+			noteSuppressed(reason)
 		}
+		return
 	}
-	serialCSVWrite(writer, row)
-}
 
-// Determines if the entry should be logged to stdout
-func shouldDropEntry(entry *logpb.LogEntry) bool {
-	if config.MatchRule == "drop-no-match" {
-		for _, log := range config.Logs {
-			lnMatch := logName(entry) == log.Name
-			if !lnMatch {
-				continue
-			}
-			typeMatch := true
-			if log.ResType != "" {
-				typeMatch = entry.Resource.Type == log.ResType
-			}
-			if typeMatch {
-				return false
-			}
-		}
-		return true
+	rec, err := tailorer.Record(entry)
+	if errors.Is(err, tailor.ErrDropped) {
+		noteSuppressed("script")
+		return
 	}
-	return false
-}
-
-func createLogItem(entry *logpb.LogEntry) (OutputMap, *Log) {
-	item := make(OutputMap)
-	lname := logName(entry)
-	var match *Log
-
-	addOutputToItem(config.Common, item, entry)
-
-	// Find the first matching log and use its outputs
-	for i := 0; i < len(config.Logs); i++ {
-		log := &config.Logs[i]
-		if lname == log.Name {
-			if log.ResType != "" && log.ResType != entry.Resource.Type {
-				continue
-			}
-			if len(log.Output) > 0 {
-				addOutputToItem(log.Output, item, entry)
-				match = log
-				break
-			}
+	if err != nil {
+		logger.Printf("Dropping entry %s: %v", entry.InsertId, err)
+		var se *tailor.ScriptError
+		if errors.As(err, &se) {
+			noteSuppressed("script-error")
+		} else {
+			noteSuppressed("missing-field")
 		}
+		return
 	}
-
-	if len(item) == 0 {
-		// There were no outputs specified so we use all the data
-		addEntryToItem(item, entry)
-	}
-	return item, match
+	writeRecords(rec)
 }
 
-func addEntryToItem(item OutputMap, entry *logpb.LogEntry) {
-	val := reflect.ValueOf(entry.Payload)
-
-	switch entry.Payload.(type) {
-	case *logpb.LogEntry_ProtoPayload:
-		pp := val.Elem().Interface().(logpb.LogEntry_ProtoPayload)
-		item["protoPayload"] = getProtoPayload(pp)
-
-	case *logpb.LogEntry_JsonPayload:
-		jp := val.Elem().Interface().(logpb.LogEntry_JsonPayload)
-		item["jsonPayload"] = jp.JsonPayload.AsMap()
-
-	case *logpb.LogEntry_TextPayload:
-		tp := val.Elem().Interface().(logpb.LogEntry_TextPayload)
-		item["textPayload"] = tp.TextPayload
-
-	default:
-		item["payload"] = entry.Payload
-	}
-
-	fixedLogName, _ := url.PathUnescape(entry.LogName)
-	item["logName"] = fixedLogName
-	if entry.Resource != nil {
-		item["resource"] = entry.Resource
-	}
-	item["timestamp"] = entry.Timestamp.AsTime().Format(time.RFC3339Nano)
-	item["receiveTimestamp"] = entry.ReceiveTimestamp.AsTime().Format(time.RFC3339Nano)
-	item["severity"] = entry.Severity
-	item["insertId"] = entry.InsertId
-	if entry.HttpRequest != nil {
-		item["httpRequest"] = entry.HttpRequest
-	}
-	item["labels"] = entry.Labels
-	if entry.Operation != nil {
-		item["operation"] = entry.Operation
+// Writes to the sink and flushes, unless it's flushed on a timer. The
+// caller holds configMU.
+func writeRecords(recs ...*tailor.Record) {
+	if len(recs) == 0 {
+		return
+	}
+	start := time.Now()
+	var err error
+	written := 0
+	for _, rec := range recs {
+		if err = sink.Write(rec); err != nil {
+			break
+		}
+		written++
 	}
-	item["trace"] = entry.Trace
-	item["spanid"] = entry.SpanId
-	item["tracesampled"] = entry.TraceSampled
-	if entry.SourceLocation != nil {
-		item["sourcelocation"] = entry.SourceLocation
+	if flushesLater(sink) {
+		// Records it refuses come back from flushSink.
+	} else if ferr := sink.Flush(); ferr != nil {
+		// What was written is stuck in the buffer.
+		err, written = ferr, 0
 	}
-	if entry.Split != nil {
-		item["split"] = entry.Split
+	if err != nil {
+		stderrf("%v\n", err)
 	}
-}
+	noteSinkWrite(sinkName(config), time.Since(start), written, err)
 
-func addOutputToItem(outputs []OutputMap, item OutputMap, entry *logpb.LogEntry) {
-	for _, oi := range outputs {
-		name := fieldName(oi)
-		addToItem(name, oi[name], item, entry)
+	if !config.Buffered {
+		os.Stdout.Sync()
 	}
 }
 
-func addToItem(name string, oi any, item OutputMap, entry *logpb.LogEntry) {
-	if om, ok := oi.(OutputMap); ok {
-		if hasKeys(om, "src", "regex", "value") {
-			if src, ok := entryData(entry, strVal(om, "src")).(string); ok {
-				rgx := strVal(om, "regex")
-				val := strVal(om, "value")
-				item[name] = regexVal(src, rgx, val)
-			}
-		} else {
-			newItem := OutputMap{}
-			item[name] = newItem
-			for k := range om {
-				addToItem(k, om[k], newItem, entry)
-			}
-		}
-	} else {
-		if v, ok := oi.(string); ok {
-			item[name] = entryData(entry, v)
+const AlertQueueSize int = 1024
+
+// Alerts wait here to be written, so a slow alert sink (like a webhook)
+// doesn't hold configMU and with it the workers and reloads.
+var alertQueue = make(chan *tailor.Record, AlertQueueSize)
+
+// Held while an alert is written, so a reload doesn't close the alert sink
+// out from under it.
+var alertMU sync.Mutex
+
+// Queues alerts for writeQueuedAlerts. The caller holds configMU. If the
+// queue is full, the alert is dropped and counted as a write error.
+func writeAlerts(alerts []*tailor.Record) {
+	for _, rec := range alerts {
+		select {
+		case alertQueue <- rec:
+		default:
+			err := errors.New("the alert queue is full. Dropping an alert")
+			stderrf("%v\n", err)
+			rule, _ := rec.Get("alert")
+			noteAlert(tailor.StringValue(rule), 0, err)
 		}
 	}
 }
 
-func addOutputToRow(outputs []OutputMap, item OutputMap, row []string) []string {
-	for _, m := range outputs {
-		for k := range m {
-			switch v := item[k].(type) {
-			case string:
-				row = append(row, v)
-			default:
-				bytes, err := json.Marshal(v)
-				if err != nil {
-					stderrf("Error marshaling key %s: %v\n", k, err)
-					continue
-				}
-				row = append(row, string(bytes))
-			}
-		}
+// Writes queued alerts to the alert sink until the queue is closed.
+func writeQueuedAlerts(done chan<- struct{}) {
+	defer close(done)
+	for rec := range alertQueue {
+		writeAlert(rec)
 	}
-	return row
 }
 
-func logName(entry *logpb.LogEntry) string {
-	re := regexp.MustCompile("^.*/(.*)$")
-	m := re.FindStringSubmatch(entry.LogName)
-	if m == nil {
-		logger.Printf("Something is fundamentally broken with logging LogName: %s", entry.LogName)
-		return entry.LogName
+func writeAlert(rec *tailor.Record) {
+	alertMU.Lock()
+	defer alertMU.Unlock()
+	configMU.RLock()
+	s := alertSink
+	configMU.RUnlock()
+	if s == nil {
+		return // a reload took the alert rules out
 	}
-	fixed, _ := url.PathUnescape(m[1])
-	return fixed
-}
 
-// Gets data from the LogEntry with a dot-separated path as a specifier.
-// example path: resources.labels.project_id
-func entryData(entry *logpb.LogEntry, path string) any {
-	val := reflect.ValueOf(entry)
-
-	for i, field := range pathElements(path) {
-		// Dereference pointers if necessary
-		if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
-			val = val.Elem()
-		}
-
-		// Compensate for cloud logging's GUIs transforming payload to different names
-		if i == 0 && (field == "protoPayload" || field == "jsonPayload" || field == "textPayload") {
-			if val.Type() == reflect.TypeOf(logpb.LogEntry{}) {
-				val = reflect.ValueOf(entry.Payload)
-			}
-		}
-
-		switch val.Kind() {
-		case reflect.Ptr:
-			switch p := val.Elem().Interface().(type) {
-			case logpb.LogEntry_ProtoPayload:
-				val = reflect.ValueOf(getProtoPayload(p))
-				continue
-			case logpb.LogEntry_JsonPayload:
-				val = reflect.ValueOf(p.JsonPayload.AsMap())
-				continue
-			case logpb.LogEntry_TextPayload:
-				val = reflect.ValueOf(p.TextPayload)
-				continue
-			}
-		case reflect.Struct:
-			val = val.FieldByName(capitalize(field))
-			if !val.IsValid() {
-				return fmt.Sprintf("Field %s not found", path)
-			}
-			// Special handling for time.Time
-			if val.Type() == reflect.TypeOf(&timestamppb.Timestamp{}) {
-				ts := val.Elem().Interface().(timestamppb.Timestamp)
-				return ts.AsTime().Format(time.RFC3339Nano)
-			}
-		case reflect.Map:
-			// Access the map by key
-			mapKey := reflect.ValueOf(field)
-			val = val.MapIndex(mapKey)
-			if !val.IsValid() {
-				return fmt.Sprintf("Key %s not found in map", field)
-			}
-		}
+	start := time.Now()
+	err := s.Write(rec)
+	if ferr := s.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		stderrf("%v\n", err)
 	}
+	rule, _ := rec.Get("alert")
+	noteAlert(tailor.StringValue(rule), time.Since(start), err)
+}
 
-	// Hack for logname:
-	if path == "logName" {
-		if s, ok := val.Interface().(string); ok {
-			p, _ := url.PathUnescape(s)
-			return p
+// Writes out what the collector has ready every tick until stop is
+// closed, and then everything it has left.
+func runCollector(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(tailor.CollectorTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			configMU.RLock()
+			writeRecords(collector.Advance(now)...)
+			configMU.RUnlock()
+		case <-stop:
+			configMU.RLock()
+			writeRecords(collector.Close()...)
+			configMU.RUnlock()
+			return
 		}
 	}
-	return val.Interface()
 }
 
-func getProtoPayload(pp logpb.LogEntry_ProtoPayload) any {
-	typeURL := pp.ProtoPayload.TypeUrl
-	messageName := getMessageNameFromTypeURL(typeURL)
-	if messageName == "" {
-		return fmt.Errorf("invalid type URL: %s", typeURL)
-	}
-	fullName := protoreflect.FullName(messageName)
-	messageType, err := protoregistry.GlobalTypes.FindMessageByName(fullName)
-	if err != nil {
-		return fmt.Errorf("message type not found: %v", err)
-	}
+const ExecFlushInterval = time.Second
 
-	// Obtain the Message Descriptor from the Message Type
-	desc := messageType.Descriptor()
+// Held while the sink is flushed or closed. Take it before configMU.
+var flushMU sync.Mutex
 
-	// Create a dynamic message based on the descriptor
-	msg := dynamicpb.NewMessage(desc)
+// An exec sink's Flush waits for every record in flight to be acked, so
+// flushing it after every write would send one record at a time. It's
+// flushed by runSinkFlusher, on a reload and at the end instead.
+func flushesLater(s tailor.Sink) bool {
+	_, ok := s.(*tailor.ExecSink)
+	return ok
+}
 
-	// Unmarshal the value into the dynamic message
-	if err := proto.Unmarshal(pp.ProtoPayload.Value, msg); err != nil {
-		return fmt.Errorf("failed to unmarshal into dynamic message: %v", err)
-	}
+// Flushes the sink if it's flushed on a timer and reports the records it
+// refused since the last time.
+func flushSink() {
+	flushMU.Lock()
+	defer flushMU.Unlock()
+	configMU.RLock()
+	s, name := sink, sinkName(config)
+	configMU.RUnlock()
+	if !flushesLater(s) {
+		return
+	}
+	start := time.Now()
+	err := s.Flush()
+	noteRefused(name, time.Since(start), err)
+}
 
-	jsonBytes, err := protojson.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal dynamic message to JSON: %v", err)
+// Closes a sink that's done with. What it refuses on the way out is
+// reported like a flush. The caller holds flushMU.
+func closeSink(s tailor.Sink, name string) {
+	start := time.Now()
+	err := s.Close()
+	if flushesLater(s) {
+		noteRefused(name, time.Since(start), err)
+	} else if err != nil {
+		logger.Printf("Error closing the sink: %v", err)
 	}
+}
 
-	var payloadMap map[string]any
-	err = json.Unmarshal(jsonBytes, &payloadMap)
+// The records were counted as written when they were sent.
+func noteRefused(name string, d time.Duration, err error) {
 	if err != nil {
-		return fmt.Errorf("Failed to unmarshal JSON to map: %v", err)
+		stderrf("%v\n", err)
+		noteSinkWrite(name, d, 0, err)
 	}
-
-	return payloadMap
 }
 
-func getMessageNameFromTypeURL(typeURL string) string {
-	const prefix = "type.googleapis.com/"
-	if !strings.HasPrefix(typeURL, prefix) {
-		return ""
+// Flushes the sink every ExecFlushInterval until stop is closed.
+func runSinkFlusher(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(ExecFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushSink()
+		case <-stop:
+			return
+		}
 	}
-	return strings.TrimPrefix(typeURL, prefix)
-}
-
-var printMU sync.Mutex
-
-// Makes sure printing to stdout doesn't overlap.
-func serialCSVWrite(writer *bufio.Writer, row []string) {
-	printMU.Lock()
-	defer printMU.Unlock()
-	csvWr := csv.NewWriter(writer)
-	csvWr.Write(row)
-	csvWr.Flush()
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+// A sink whose writes wait for release.
+type slowSink struct {
+	release chan struct{}
+	written []*tailor.Record
+}
+
+func (s *slowSink) Open() error  { return nil }
+func (s *slowSink) Flush() error { return nil }
+func (s *slowSink) Close() error { return nil }
+
+func (s *slowSink) Write(rec *tailor.Record) error {
+	<-s.release
+	s.written = append(s.written, rec)
+	return nil
+}
+
+func TestWriteAlerts(t *testing.T) {
+	savedAlertSink, savedStats := alertSink, stats
+	defer func() { alertSink, stats = savedAlertSink, savedStats }()
+	stats.Alerts = map[string]int{}
+	s := &slowSink{release: make(chan struct{})}
+	alertSink = s
+
+	alert := &tailor.Record{}
+	alert.Set("alert", "iam-burst")
+	configMU.RLock()
+	writeAlerts([]*tailor.Record{alert})
+	configMU.RUnlock()
+
+	// A reload isn't held up by a slow alert sink.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeAlert(<-alertQueue)
+	}()
+	locked := make(chan struct{})
+	go func() {
+		configMU.Lock()
+		configMU.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing an alert held configMU")
+	}
+	close(s.release)
+	<-done
+	if len(s.written) != 1 || s.written[0] != alert {
+		t.Errorf("wrote %v; want the alert", s.written)
+	}
+
+	// When the queue is full, alerts are dropped and counted as errors.
+	for i := 0; i < AlertQueueSize; i++ {
+		writeAlerts([]*tailor.Record{alert})
+	}
+	writeAlerts([]*tailor.Record{alert})
+	for i := 0; i < AlertQueueSize; i++ {
+		<-alertQueue
+	}
+	if stats.WriteErrors != savedStats.WriteErrors+1 || stats.Alerts["iam-burst"] != 2 {
+		t.Errorf("write errors = %d, alerts = %v; want one dropped alert", stats.WriteErrors-savedStats.WriteErrors, stats.Alerts)
+	}
+}
+
+// Not a real test. It's the exec sink process for TestExecSinkFlush: it
+// acks every JSON line, refusing the records with a "bad" field.
+func TestExecAckProcess(t *testing.T) {
+	if os.Getenv("EXEC_ACK_PROCESS") == "" {
+		return
+	}
+	defer os.Exit(0)
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		var frame struct {
+			Seq    uint64         `json:"seq"`
+			Record map[string]any `json:"record"`
+		}
+		json.Unmarshal(in.Bytes(), &frame)
+		ack := map[string]any{"seq": frame.Seq}
+		if _, bad := frame.Record["bad"]; bad {
+			ack["error"] = "bad record"
+		}
+		line, _ := json.Marshal(ack)
+		os.Stdout.Write(append(line, '\n'))
+	}
+}
+
+func TestExecSinkFlush(t *testing.T) {
+	savedConfig, savedSink, savedStats := config, sink, stats
+	defer func() { config, sink, stats = savedConfig, savedSink, savedStats }()
+	t.Setenv("EXEC_ACK_PROCESS", "1")
+	def := &SinkDef{Type: "exec", Command: []string{os.Args[0], "-test.run=^TestExecAckProcess$"}, MaxInFlight: 2}
+	config, stats = &Config{Buffered: true, Sink: def}, runStats{}
+	s, err := openSink(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink = s
+
+	good, bad := &tailor.Record{}, &tailor.Record{}
+	good.Set("user", "alice")
+	bad.Set("bad", true)
+	configMU.RLock()
+	writeRecords(good, bad, good, good)
+	configMU.RUnlock()
+	// The refused record isn't known about until the sink is flushed.
+	if stats.Written != 4 || stats.WriteErrors != 0 {
+		t.Errorf("before flushing: written = %d, errors = %d; want 4, 0", stats.Written, stats.WriteErrors)
+	}
+	flushSink()
+	if stats.WriteErrors != 1 {
+		t.Errorf("after flushing: errors = %d; want 1", stats.WriteErrors)
+	}
+
+	configMU.RLock()
+	writeRecords(bad)
+	configMU.RUnlock()
+	flushMU.Lock()
+	closeSink(sink, sinkName(config))
+	flushMU.Unlock()
+	if stats.WriteErrors != 2 {
+		t.Errorf("after closing: errors = %d; want 2", stats.WriteErrors)
+	}
+}
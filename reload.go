@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	logger "log"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const ConfigWatchInterval = 2 * time.Second
+
+// What we know about a config file, to tell when it changes.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloads the config on SIGHUP and, with -watch, when the config files
+// change. Only -config files can be reloaded since stdin is read once.
+func watchConfig(ctx context.Context, args *cmdlnArgs, pulls *streams) {
+	if len(args.configs) == 0 {
+		if args.watch {
+			logger.Println("-watch needs -config. Not watching.")
+		}
+		return
+	}
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	var stamps map[string]fileStamp
+	if args.watch {
+		ticker := time.NewTicker(ConfigWatchInterval)
+		tick = ticker.C
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+		// Includes count too, so find out what they are.
+		paths := []string(args.configs)
+		if doc, err := loadConfig(args.configs); err == nil {
+			paths = doc.paths
+		}
+		stamps = statFiles(paths)
+	}
+
+	go func() {
+		defer signal.Stop(hupCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				logger.Println("Caught SIGHUP. Reloading the config...")
+			case <-tick:
+				if !filesChanged(stamps) {
+					continue
+				}
+				logger.Println("Config changed. Reloading...")
+			}
+
+			paths, err := reloadConfig(args, pulls)
+			if err != nil {
+				logger.Printf("Keeping the old config. Reload failed:\n%v", err)
+			}
+			if args.watch && len(paths) > 0 {
+				stamps = statFiles(paths)
+			}
+		}
+	}()
+}
+
+func statFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, p := range paths {
+		stamps[p] = statFile(p)
+	}
+	return stamps
+}
+
+// A file that's gone has a zero stamp.
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+func filesChanged(stamps map[string]fileStamp) bool {
+	for p, stamp := range stamps {
+		if statFile(p) != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads and checks the config files again and swaps the new config in.
+// Entries being processed finish with the old one. The streams are only
+// restarted if projects, logs or filters changed. Gives back the files
+// that were read.
+func reloadConfig(args *cmdlnArgs, pulls *streams) ([]string, error) {
+	doc, err := loadConfig(args.configs)
+	if err != nil {
+		return nil, err
+	}
+	if doc.root == nil {
+		return doc.paths, errors.New("the config is empty")
+	}
+	c, problems := checkConfig(doc)
+	if len(problems) > 0 {
+		return doc.paths, errors.New(strings.Join(problems, "\n"))
+	}
+	if c.MatchRule == "" {
+		c.MatchRule = "all"
+	}
+	if len(c.Projects) == 0 && len(args.projIDs) == 0 {
+		return doc.paths, errors.New("you must specify at least one project")
+	}
+	c.overrideFields(args)
+
+	configMU.RLock()
+	old, oldSink, oldAlertSink := config, sink, alertSink
+	configMU.RUnlock()
+	if columnsChanged(old, c) {
+		return doc.paths, errors.New("aggregate and traces can't change with csv output until a restart, since they decide the columns")
+	}
+
+	t, err := newTailor(&c.Config)
+	if err != nil {
+		return doc.paths, err
+	}
+	s := oldSink
+	if !keepSink(old, c) {
+		if s, err = openSink(c, t); err != nil {
+			return doc.paths, err
+		}
+	}
+	as := oldAlertSink
+	if !keepAlertSink(old, c) {
+		if as, err = openAlertSink(c); err != nil {
+			if s != oldSink {
+				s.Close()
+			}
+			return doc.paths, err
+		}
+	}
+
+	configMU.Lock()
+	config, tailorer, limiter, sink = c, t, limiter.Reload(t), s
+	alerter, alertSink = alerter.Reload(t), as
+	configMU.Unlock()
+
+	if s != oldSink {
+		flushMU.Lock()
+		closeSink(oldSink, sinkName(old))
+		flushMU.Unlock()
+	} else {
+		flushSink()
+	}
+	if as != oldAlertSink && oldAlertSink != nil {
+		// Once any alert being written to it is done.
+		alertMU.Lock()
+		if err := oldAlertSink.Close(); err != nil {
+			logger.Printf("Error closing the old alert sink: %v", err)
+		}
+		alertMU.Unlock()
+	}
+
+	if keys := restartNeeded(old, c); len(keys) > 0 {
+		logger.Printf("Changes to %s need a restart to take effect.", strings.Join(keys, ", "))
+	}
+	if streamsChanged(old, c) {
+		logger.Println("Projects, logs or filters changed. Restarting the streams...")
+		pulls.restart()
+	}
+	logger.Println("Config reloaded.")
+	return doc.paths, nil
+}
+
+// True if the new config tails something different.
+func streamsChanged(old, c *Config) bool {
+	oldLogs, newLogs := logsToSet(old.Logs), logsToSet(c.Logs)
+	sort.Strings(oldLogs)
+	sort.Strings(newLogs)
+	return !slices.Equal(old.Projects, c.Projects) ||
+		!slices.Equal(old.Filters, c.Filters) ||
+		!slices.Equal(oldLogs, newLogs)
+}
+
+// Settings that are only used at startup.
+func restartNeeded(old, c *Config) []string {
+	var keys []string
+	if old.MetricsAddr != c.MetricsAddr {
+		keys = append(keys, "metrics-addr")
+	}
+	if !reflect.DeepEqual(old.Metrics, c.Metrics) {
+		keys = append(keys, "metrics")
+	}
+	if old.Checkpoint != c.Checkpoint {
+		keys = append(keys, "checkpoint")
+	}
+	if !reflect.DeepEqual(old.Ordering, c.Ordering) {
+		keys = append(keys, "ordering")
+	}
+	if !reflect.DeepEqual(old.Dedup, c.Dedup) {
+		keys = append(keys, "dedup")
+	}
+	if !reflect.DeepEqual(old.Aggregate, c.Aggregate) {
+		keys = append(keys, "aggregate")
+	}
+	if !reflect.DeepEqual(old.Traces, c.Traces) {
+		keys = append(keys, "traces")
+	}
+	return keys
+}
+
+// The collector keeps the aggregate: or traces: it was started with, so a
+// CSV sink opened with the new ones would have the wrong header.
+func columnsChanged(old, c *Config) bool {
+	return sinkName(c) == "csv" &&
+		(!reflect.DeepEqual(old.Aggregate, c.Aggregate) || !reflect.DeepEqual(old.Traces, c.Traces))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+func TestReloadConfig(t *testing.T) {
+	savedConfig, savedTailor, savedSink := config, tailorer, sink
+	savedLimiter, savedAlerter := limiter, alerter
+	defer func() {
+		config, tailorer, sink = savedConfig, savedTailor, savedSink
+		limiter, alerter = savedLimiter, savedAlerter
+	}()
+
+	dir := writeConfigFiles(t, map[string]string{
+		"good.yaml": `
+projects: [proj]
+logs:
+- name: app
+  output:
+  - user: jsonPayload.user
+`,
+		"bad.yaml": `
+projects: [proj]
+match_rule: all
+`,
+	})
+	old := &Config{Projects: []string{"proj"}, Format: "jsonl"}
+	old.Logs = []tailor.Log{{Name: "app"}}
+	old.MatchRule = "all"
+	config, sink = old, tailor.NewJSONLSink(io.Discard)
+	tailorer, _ = newTailor(&old.Config)
+	limiter, alerter = tailor.NewLimiter(tailorer), tailor.NewAlerter(tailorer)
+
+	// Nothing should get restarted, and with this it couldn't be anyway.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pulls := &streams{ctx: ctx}
+
+	args := &cmdlnArgs{configs: stringList{filepath.Join(dir, "bad.yaml")}, format: "jsonl"}
+	if _, err := reloadConfig(args, pulls); err == nil || !strings.Contains(err.Error(), "match_rule") {
+		t.Errorf("expected the bad config to be rejected, got %v", err)
+	}
+	if config != old {
+		t.Errorf("a failed reload shouldn't change the config")
+	}
+
+	args.configs = stringList{filepath.Join(dir, "good.yaml")}
+	paths, err := reloadConfig(args, pulls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("paths = %v", paths)
+	}
+	if config == old || len(config.Logs[0].Output) != 1 || config.Format != "jsonl" {
+		t.Errorf("config wasn't swapped: %+v", config)
+	}
+}
+
+func streamsConfig(projects []string, logs []tailor.Log, filters []string) *Config {
+	c := &Config{Projects: projects, Filters: filters}
+	c.Logs = logs
+	return c
+}
+
+func TestStreamsChanged(t *testing.T) {
+	base := streamsConfig([]string{"a"}, []tailor.Log{{Name: "x"}, {Name: "y"}}, []string{"severity>=ERROR"})
+	tests := []struct {
+		name     string
+		c        *Config
+		expected bool
+	}{
+		{"same", streamsConfig([]string{"a"}, []tailor.Log{{Name: "y"}, {Name: "x", ResType: "gce"}}, []string{"severity>=ERROR"}), false},
+		{"outputs only", streamsConfig([]string{"a"}, []tailor.Log{{Name: "x", Output: []tailor.OutputMap{{"a": "b"}}}, {Name: "y"}}, []string{"severity>=ERROR"}), false},
+		{"project", streamsConfig([]string{"b"}, base.Logs, base.Filters), true},
+		{"log", streamsConfig(base.Projects, []tailor.Log{{Name: "x"}}, base.Filters), true},
+		{"filter", streamsConfig(base.Projects, base.Logs, nil), true},
+	}
+	for _, tt := range tests {
+		if got := streamsChanged(base, tt.c); got != tt.expected {
+			t.Errorf("%s: streamsChanged() = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestKeepSink(t *testing.T) {
+	exec := &SinkDef{Type: "exec", Command: []string{"./sink"}}
+	tests := []struct {
+		name     string
+		old, c   *SinkDef
+		expected bool
+	}{
+		{"same exec", exec, &SinkDef{Type: "exec", Command: []string{"./sink"}}, true},
+		{"new command", exec, &SinkDef{Type: "exec", Command: []string{"./other"}}, false},
+		{"stdout", nil, nil, false},
+		{"to stdout", exec, nil, false},
+	}
+	for _, tt := range tests {
+		if got := keepSink(&Config{Sink: tt.old}, &Config{Sink: tt.c}); got != tt.expected {
+			t.Errorf("%s: keepSink() = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestRestartNeeded(t *testing.T) {
+	metric := MetricDef{Name: "errors", Type: "counter", Labels: map[string]string{"project": "resource.labels.project_id"}}
+	relabeled := metric
+	relabeled.Labels = map[string]string{"zone": "resource.labels.zone"}
+	tests := []struct {
+		name     string
+		old, c   *Config
+		expected []string
+	}{
+		{"same", &Config{Metrics: []MetricDef{metric}}, &Config{Metrics: []MetricDef{metric}}, nil},
+		{"new labels", &Config{Metrics: []MetricDef{metric}}, &Config{Metrics: []MetricDef{relabeled}}, []string{"metrics"}},
+		{"checkpoint", &Config{}, &Config{Checkpoint: "cp.json"}, []string{"checkpoint"}},
+	}
+	for _, tt := range tests {
+		if got := restartNeeded(tt.old, tt.c); !slices.Equal(got, tt.expected) {
+			t.Errorf("%s: restartNeeded() = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestColumnsChanged(t *testing.T) {
+	csv := &Config{Format: "csv"}
+	csv.Aggregate = &tailor.Aggregate{Window: time.Minute}
+	tests := []struct {
+		name      string
+		format    string
+		aggregate *tailor.Aggregate
+		traces    *tailor.Traces
+		expected  bool
+	}{
+		{"same", "csv", &tailor.Aggregate{Window: time.Minute}, nil, false},
+		{"new window", "csv", &tailor.Aggregate{Window: time.Hour}, nil, true},
+		{"to traces", "csv", nil, &tailor.Traces{}, true},
+		{"jsonl", "jsonl", nil, nil, false},
+	}
+	for _, tt := range tests {
+		c := &Config{Format: tt.format}
+		c.Aggregate, c.Traces = tt.aggregate, tt.traces
+		if got := columnsChanged(csv, c); got != tt.expected {
+			t.Errorf("%s: columnsChanged() = %v; want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestReloadKeepsLimitsAndAlerts(t *testing.T) {
+	savedConfig, savedTailor, savedSink, savedAlertSink := config, tailorer, sink, alertSink
+	savedLimiter, savedAlerter := limiter, alerter
+	defer func() {
+		config, tailorer, sink, alertSink = savedConfig, savedTailor, savedSink, savedAlertSink
+		limiter, alerter = savedLimiter, savedAlerter
+	}()
+
+	dir := writeConfigFiles(t, map[string]string{
+		"tail.yaml": `
+projects: [proj]
+logs:
+- name: app
+  rate-limit: {rate: 0.001, burst: 1}
+alerts:
+- name: errors
+  when: severity >= 500
+  cooldown: 1h
+`,
+	})
+	old := &Config{Projects: []string{"proj"}, Format: "jsonl"}
+	old.MatchRule = "all"
+	config, sink = old, tailor.NewJSONLSink(io.Discard)
+	tailorer, _ = newTailor(&old.Config)
+	limiter, alerter = tailor.NewLimiter(tailorer), tailor.NewAlerter(tailorer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pulls := &streams{ctx: ctx}
+	args := &cmdlnArgs{configs: stringList{filepath.Join(dir, "tail.yaml")}, format: "jsonl"}
+	reload := func() {
+		t.Helper()
+		if _, err := reloadConfig(args, pulls); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	entry := func() *logpb.LogEntry {
+		n++
+		return &logpb.LogEntry{
+			LogName:   "projects/proj/logs/app",
+			InsertId:  fmt.Sprint(n),
+			Severity:  ltype.LogSeverity_ERROR,
+			Timestamp: timestamppb.Now(),
+		}
+	}
+
+	reload()
+	if ok, _ := limiter.Allow(entry()); !ok {
+		t.Fatal("the first entry should get through")
+	}
+	if ok, _ := limiter.Allow(entry()); ok {
+		t.Fatal("the burst should be used up")
+	}
+	if alerts := alerter.Check(entry()); len(alerts) != 1 {
+		t.Fatalf("%d alerts; want 1", len(alerts))
+	}
+
+	reload()
+	if ok, _ := limiter.Allow(entry()); ok {
+		t.Errorf("a reload refilled the rate-limit bucket")
+	}
+	if alerts := alerter.Check(entry()); len(alerts) != 0 {
+		t.Errorf("a reload ended the alert's cooldown: %v", alerts)
+	}
+}
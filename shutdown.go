@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	logger "log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+var caughtSignal os.Signal
+var sigMU sync.Mutex
+
+// os.Exit, but tests can swap it.
+var exit = os.Exit
+
+// Cancels the context on the first SIGINT/SIGTERM so everything can drain and
+// flush. A second signal means the user is impatient and we just leave.
+func handleSignals(cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	shutdownOnSignals(sigCh, cancel)
+}
+
+func shutdownOnSignals(sigCh <-chan os.Signal, cancel context.CancelFunc) {
+	sig := <-sigCh
+	sigMU.Lock()
+	caughtSignal = sig
+	sigMU.Unlock()
+	logger.Printf("Caught %v. Draining and shutting down...", sig)
+	cancel()
+
+	sig = <-sigCh
+	logger.Printf("Caught %v again. Exiting now.", sig)
+	exit(exitCode(sig))
+}
+
+// Returns the exit status we should use. Zero unless a signal stopped us.
+func shutdownExitCode() int {
+	sigMU.Lock()
+	defer sigMU.Unlock()
+	if caughtSignal == nil {
+		return 0
+	}
+	return exitCode(caughtSignal)
+}
+
+// Same convention as the shell: 128 + the signal number.
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// The last entry we pulled for each project.
+type checkpoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	InsertID  string    `json:"insertId"`
+}
+
+var checkpoints = map[string]checkpoint{}
+var cpMU sync.Mutex
+
+func recordCheckpoint(projID string, entry *logpb.LogEntry) {
+	if currentConfig().Checkpoint == "" {
+		return
+	}
+	cpMU.Lock()
+	defer cpMU.Unlock()
+	ts := entry.Timestamp.AsTime()
+	if cp, ok := checkpoints[projID]; ok && cp.Timestamp.After(ts) {
+		return
+	}
+	checkpoints[projID] = checkpoint{Timestamp: ts, InsertID: entry.InsertId}
+}
+
+// Writes the checkpoint file if one is configured.
+func writeCheckpoint() {
+	path := currentConfig().Checkpoint
+	if path == "" {
+		return
+	}
+	cpMU.Lock()
+	defer cpMU.Unlock()
+	bytes, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		logger.Printf("Error marshaling checkpoint: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, append(bytes, '\n'), 0644); err != nil {
+		logger.Printf("Error writing checkpoint %s: %v", path, err)
+	}
+}
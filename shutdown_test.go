@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCheckpoint(t *testing.T) {
+	savedConfig := config
+	defer func() { config, checkpoints = savedConfig, map[string]checkpoint{} }()
+	file := filepath.Join(t.TempDir(), "checkpoint.json")
+	config = &Config{Checkpoint: file}
+
+	base := time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)
+	for _, e := range []struct {
+		offset time.Duration
+		id     string
+	}{
+		{500 * time.Millisecond, "a"},
+		{0, "b"}, // older, but "…:05Z" sorts after "…:05.5Z" as a string
+		{time.Second, "c"},
+		{900 * time.Millisecond, "d"},
+	} {
+		recordCheckpoint("proj", &logpb.LogEntry{InsertId: e.id, Timestamp: timestamppb.New(base.Add(e.offset))})
+	}
+	writeCheckpoint()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "proj": {
+    "timestamp": "2024-05-01T12:00:06Z",
+    "insertId": "c"
+  }
+}
+`
+	if string(data) != expected {
+		t.Errorf("checkpoint =\n%s\nwant\n%s", data, expected)
+	}
+}
+
+func TestPutEntryIntoChannelLimit(t *testing.T) {
+	savedConfig, savedCount := config, pullCount
+	defer func() { config, pullCount = savedConfig, savedCount }()
+	config, pullCount = &Config{Limit: 2}, 0
+
+	ch := make(chan *logpb.LogEntry, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expected := []struct{ sent, more bool }{{true, true}, {true, false}, {false, false}}
+	for i, e := range expected {
+		sent, more := putEntryIntoChannel(&logpb.LogEntry{}, ch, cancel)
+		if sent != e.sent || more != e.more {
+			t.Errorf("entry %d: sent %v, more %v; want %v, %v", i+1, sent, more, e.sent, e.more)
+		}
+	}
+	if len(ch) != 2 {
+		t.Errorf("%d entries sent; want 2", len(ch))
+	}
+	if ctx.Err() == nil {
+		t.Errorf("hitting the limit should cancel")
+	}
+}
+
+func TestShutdownOnSignals(t *testing.T) {
+	savedExit := exit
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() {
+		exit = savedExit
+		sigMU.Lock()
+		caughtSignal = nil
+		sigMU.Unlock()
+	}()
+
+	if code := shutdownExitCode(); code != 0 {
+		t.Errorf("exit code %d before any signal", code)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	go shutdownOnSignals(sigCh, cancel)
+
+	sigCh <- syscall.SIGTERM
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first signal didn't cancel")
+	}
+	if code := shutdownExitCode(); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("exit code %d after SIGTERM; want %d", code, 128+int(syscall.SIGTERM))
+	}
+
+	sigCh <- syscall.SIGINT
+	select {
+	case code := <-exited:
+		if code != 128+int(syscall.SIGINT) {
+			t.Errorf("second signal exited with %d; want %d", code, 128+int(syscall.SIGINT))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second signal didn't exit")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := exitCode(syscall.SIGINT); code != 130 {
+		t.Errorf("SIGINT = %d; want 130", code)
+	}
+	if code := exitCode(fakeSignal{}); code != 1 {
+		t.Errorf("a non-syscall signal = %d; want 1", code)
+	}
+}
+
+type fakeSignal struct{}
+
+func (fakeSignal) String() string { return "fake" }
+func (fakeSignal) Signal()        {}
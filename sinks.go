@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+// Where records go. Off (stdout in -format) if nil.
+type SinkDef struct {
+	Type string `yaml:"type"` // stdout, stderr, exec or webhook
+	// For exec: the program and its args, and how records are framed
+	// (jsonl or protobuf).
+	Command     []string `yaml:"command"`
+	Framing     string   `yaml:"framing"`
+	MaxInFlight int      `yaml:"max-in-flight"`
+	// For webhook: where records are POSTed, and extra headers. $VARS in
+	// the headers are expanded so tokens can stay out of the config.
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Makes the sink for the config and opens it.
+func openSink(c *Config, t *tailor.Tailor) (tailor.Sink, error) {
+	var s tailor.Sink
+	out := os.Stdout
+	if c.Sink != nil && c.Sink.Type == "stderr" {
+		out = os.Stderr
+	}
+	switch {
+	case c.Sink != nil && (c.Sink.Type == "exec" || c.Sink.Type == "webhook"):
+		s = newRemoteSink(c.Sink)
+	case c.Format == "jsonl":
+		s = tailor.NewJSONLSink(out)
+	case c.Format == "csv":
+		s = tailor.NewCSVSink(out, t.Columns())
+	default:
+		s = tailor.NewYAMLSink(out)
+	}
+	if err := s.Open(); err != nil {
+		return nil, fmt.Errorf("opening the sink: %v", err)
+	}
+	return s, nil
+}
+
+// Makes the sink for alerts and opens it. Alerts go to stderr as JSON
+// lines unless alert-sink says otherwise. Nil if there are no alert rules.
+func openAlertSink(c *Config) (tailor.Sink, error) {
+	if len(c.Alerts) == 0 {
+		return nil, nil
+	}
+	var s tailor.Sink
+	switch def := c.AlertSink; {
+	case def != nil && (def.Type == "exec" || def.Type == "webhook"):
+		s = newRemoteSink(def)
+	case def != nil && def.Type == "stdout":
+		s = tailor.NewJSONLSink(os.Stdout)
+	default:
+		s = tailor.NewJSONLSink(os.Stderr)
+	}
+	if err := s.Open(); err != nil {
+		return nil, fmt.Errorf("opening the alert sink: %v", err)
+	}
+	return s, nil
+}
+
+// An exec or webhook sink.
+func newRemoteSink(def *SinkDef) tailor.Sink {
+	if def.Type == "webhook" {
+		ws := tailor.NewWebhookSink(def.URL)
+		ws.Headers = map[string]string{}
+		for k, v := range def.Headers {
+			ws.Headers[k] = os.ExpandEnv(v)
+		}
+		return ws
+	}
+	es := tailor.NewExecSink(def.Command, def.Framing)
+	es.MaxInFlight = def.MaxInFlight
+	return es
+}
+
+// What the sink metrics are labeled with.
+func sinkName(c *Config) string {
+	if c.Sink != nil && (c.Sink.Type == "exec" || c.Sink.Type == "webhook") {
+		return c.Sink.Type
+	}
+	return c.Format
+}
+
+// An exec sink is kept over a reload if it's set up the same, so the
+// process isn't restarted for nothing. The others are cheap to replace.
+func keepSink(old, c *Config) bool {
+	return keepSinkDef(old.Sink, c.Sink)
+}
+
+// The same for the alert sink.
+func keepAlertSink(old, c *Config) bool {
+	return len(old.Alerts) > 0 && len(c.Alerts) > 0 && keepSinkDef(old.AlertSink, c.AlertSink)
+}
+
+func keepSinkDef(old, def *SinkDef) bool {
+	return def != nil && def.Type == "exec" && reflect.DeepEqual(old, def)
+}
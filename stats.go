@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	logger "log"
+	"os"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"github.com/zonkhead/log-tailor/tailor"
+)
+
+// What happened during the run. Reported at exit with -summary.
+type runStats struct {
+	WallTime     string         `json:"wallTime" yaml:"wall-time"`
+	WallSeconds  float64        `json:"wallSeconds" yaml:"wall-seconds"`
+	Received     int            `json:"received" yaml:"received"`
+	Written      int            `json:"written" yaml:"written"`
+	Projects     map[string]int `json:"projects" yaml:"projects"`
+	Logs         map[string]int `json:"logs" yaml:"logs"`
+	Severities   map[string]int `json:"severities" yaml:"severities"`
+	Dropped      map[string]int `json:"dropped" yaml:"dropped"`
+	Suppressed   map[string]int `json:"suppressed" yaml:"suppressed"`
+	Late         int            `json:"late" yaml:"late"`
+	Reconnects   int            `json:"reconnects" yaml:"reconnects"`
+	DecodeErrors int            `json:"decodeErrors" yaml:"decode-errors"`
+	WriteErrors  int            `json:"writeErrors" yaml:"write-errors"`
+	Alerts       map[string]int `json:"alerts" yaml:"alerts"`
+}
+
+var stats = runStats{
+	Projects:   map[string]int{},
+	Logs:       map[string]int{},
+	Severities: map[string]int{},
+	Dropped:    map[string]int{},
+	Suppressed: map[string]int{},
+	Alerts:     map[string]int{},
+}
+var statsMU sync.Mutex
+var startTime = time.Now()
+
+// The note* functions keep the exit summary and the Prometheus metrics in step.
+
+func noteReceived(projID string, entry *logpb.LogEntry) {
+	entriesReceived.WithLabelValues(projID).Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Received++
+	stats.Projects[projID]++
+	stats.Logs[tailor.LogName(entry)]++
+	stats.Severities[entry.Severity.String()]++
+}
+
+func noteDropped(matchRule string) {
+	entriesDropped.Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Dropped[matchRule]++
+}
+
+func noteSuppressed(reason string) {
+	entriesSuppressed.WithLabelValues(reason).Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Suppressed[reason]++
+}
+
+func noteLate() {
+	lateEntries.Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Late++
+}
+
+func noteReconnect(projID string) {
+	reconnects.WithLabelValues(projID).Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Reconnects++
+}
+
+func noteDecodeFailure() {
+	protoDecodeFailures.Inc()
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.DecodeErrors++
+}
+
+// written is how many of the records made it to the sink.
+func noteSinkWrite(sink string, d time.Duration, written int, err error) {
+	observeSinkWrite(sink, d, err)
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Written += written
+	if err != nil {
+		stats.WriteErrors++
+	}
+}
+
+// Alerts aren't counted as written records.
+func noteAlert(rule string, d time.Duration, err error) {
+	alertsFired.WithLabelValues(rule).Inc()
+	observeSinkWrite("alerts", d, err)
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	stats.Alerts[rule]++
+	if err != nil {
+		stats.WriteErrors++
+	}
+}
+
+// Writes the summary to stderr (as yaml) or to a JSON file.
+func writeSummary(dest string) {
+	if dest == "" {
+		return
+	}
+	statsMU.Lock()
+	defer statsMU.Unlock()
+	wall := time.Since(startTime)
+	stats.WallTime = wall.Round(time.Millisecond).String()
+	stats.WallSeconds = wall.Seconds()
+
+	if dest == "stderr" || dest == "-" {
+		stderrln("--- summary")
+		stderr(stats)
+		return
+	}
+
+	bytes, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		logger.Printf("Error marshaling summary: %v", err)
+		return
+	}
+	if err := os.WriteFile(dest, append(bytes, '\n'), 0644); err != nil {
+		logger.Printf("Error writing summary %s: %v", dest, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/zonkhead/log-tailor/tailor"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+)
+
+func TestSummaryCounts(t *testing.T) {
+	savedConfig, savedCount, savedDedup, savedStats := config, pullCount, dedup, stats
+	defer func() { config, pullCount, dedup, stats = savedConfig, savedCount, savedDedup, savedStats }()
+	config, pullCount = &Config{Limit: 3}, 0
+	dedup = newDedupCache(&Dedup{})
+	stats = runStats{
+		Projects:   map[string]int{},
+		Logs:       map[string]int{},
+		Severities: map[string]int{},
+		Dropped:    map[string]int{},
+		Suppressed: map[string]int{},
+		Alerts:     map[string]int{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &projectSource{projID: "proj", cancel: cancel}
+	ch := make(chan *logpb.LogEntry, 5)
+	entry := func(id, log string, sev ltype.LogSeverity) *logpb.LogEntry {
+		return &logpb.LogEntry{InsertId: id, LogName: "projects/proj/logs/" + log, Severity: sev}
+	}
+	for _, e := range []*logpb.LogEntry{
+		entry("1", "app", ltype.LogSeverity_INFO),
+		entry("1", "app", ltype.LogSeverity_INFO), // a duplicate
+		entry("2", "app", ltype.LogSeverity_ERROR),
+		entry("3", "audit", ltype.LogSeverity_INFO), // hits -limit
+		entry("4", "audit", ltype.LogSeverity_INFO), // past it
+	} {
+		src.admit(e, ch)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("-limit wasn't hit")
+	}
+	noteDropped("all")
+	noteSinkWrite("jsonl", 0, 3, nil)
+	noteSinkWrite("jsonl", 0, 1, errors.New("broken pipe"))
+	noteAlert("too-many-errors", 0, nil)
+
+	file := filepath.Join(t.TempDir(), "summary.json")
+	writeSummary(file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runStats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := runStats{
+		WallTime:    got.WallTime,
+		WallSeconds: got.WallSeconds,
+		Received:    3,
+		Written:     4,
+		Projects:    map[string]int{"proj": 3},
+		Logs:        map[string]int{"app": 2, "audit": 1},
+		Severities:  map[string]int{"INFO": 2, "ERROR": 1},
+		Dropped:     map[string]int{"all": 1},
+		Suppressed:  map[string]int{"duplicate": 1},
+		WriteErrors: 1,
+		Alerts:      map[string]int{"too-many-errors": 1},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("summary = %+v; want %+v", got, expected)
+	}
+	if got.WallTime == "" {
+		t.Errorf("no wall time")
+	}
+}
+
+// A sink that fails after n writes.
+type failingSink struct {
+	n int
+}
+
+func (s *failingSink) Open() error  { return nil }
+func (s *failingSink) Flush() error { return nil }
+func (s *failingSink) Close() error { return nil }
+
+func (s *failingSink) Write(rec *tailor.Record) error {
+	if s.n == 0 {
+		return errors.New("broken pipe")
+	}
+	s.n--
+	return nil
+}
+
+func TestWriteRecordsCounts(t *testing.T) {
+	savedConfig, savedSink, savedStats := config, sink, stats
+	defer func() { config, sink, stats = savedConfig, savedSink, savedStats }()
+	config, stats = &Config{Buffered: true}, runStats{}
+
+	// A collector's records go out in one call.
+	recs := []*tailor.Record{{}, {}, {}}
+	sink = tailor.NewJSONLSink(io.Discard)
+	writeRecords(recs...)
+	if stats.Written != 3 || stats.WriteErrors != 0 {
+		t.Errorf("written = %d, errors = %d; want 3, 0", stats.Written, stats.WriteErrors)
+	}
+
+	sink = &failingSink{n: 1}
+	writeRecords(recs...)
+	if stats.Written != 4 || stats.WriteErrors != 1 {
+		t.Errorf("written = %d, errors = %d; want 4, 1", stats.Written, stats.WriteErrors)
+	}
+}
@@ -0,0 +1,272 @@
+package tailor
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+// Rolls entries up into one record per group per window instead of a
+// record per entry. Windows go by entry timestamp.
+type Aggregate struct {
+	Window time.Duration `yaml:"window"`
+	// Windows start this often and overlap if it's less than the window.
+	// Tumbling windows (the same as the window) if 0.
+	Slide time.Duration `yaml:"slide"`
+	// How far behind the latest entry timestamp a window's end has to be
+	// before it's output, to wait for entries that are late. It's also how
+	// long to go without entries before the clock takes over. Default
+	// DefaultAggregateLateness.
+	Lateness time.Duration `yaml:"lateness"`
+	// Output names and the paths to group by.
+	GroupBy []OutputMap `yaml:"group-by"`
+	// Numeric paths to get the min, max and avg of.
+	Values []OutputMap `yaml:"values"`
+	// Groups in one window. Entries for more are dropped.
+	MaxGroups int `yaml:"max-groups"`
+}
+
+const (
+	DefaultAggregateLateness  = 5 * time.Second
+	DefaultAggregateMaxGroups = 10000
+)
+
+// Why an Aggregator didn't take an entry.
+const (
+	LateEntry     = "late"
+	TooManyGroups = "too-many-groups"
+)
+
+// The Collector for config.Aggregate. Safe for use by multiple goroutines.
+type Aggregator struct {
+	t        *Tailor
+	a        *Aggregate
+	slide    time.Duration
+	lateness time.Duration
+
+	mu      sync.Mutex
+	windows map[int64]*aggWindow // by start
+	maxSeen time.Time
+	lastAdd time.Time // wall clock, for flushing when it's quiet
+	done    time.Time // windows ending before this have been output
+}
+
+type aggWindow struct {
+	start  time.Time
+	groups map[string]*aggGroup
+	order  []*aggGroup
+}
+
+type aggGroup struct {
+	keys        []any
+	count       int
+	first, last time.Time
+	values      []aggValue
+}
+
+type aggValue struct {
+	n             int
+	min, max, sum float64
+}
+
+func NewAggregator(t *Tailor) *Aggregator {
+	a := t.config.Aggregate
+	g := &Aggregator{t: t, a: a, slide: a.Slide, lateness: a.Lateness, windows: map[int64]*aggWindow{}}
+	if g.slide <= 0 || g.slide > a.Window {
+		g.slide = a.Window
+	}
+	if g.lateness <= 0 {
+		g.lateness = DefaultAggregateLateness
+	}
+	return g
+}
+
+// Adds the entry to every window it's in. The reason is LateEntry if
+// they've been output already or TooManyGroups.
+func (g *Aggregator) Add(entry *logpb.LogEntry) (bool, string) {
+	return g.addAt(entry, time.Now())
+}
+
+func (g *Aggregator) addAt(entry *logpb.LogEntry, now time.Time) (bool, string) {
+	ts := now
+	if entry.Timestamp != nil {
+		ts = entry.Timestamp.AsTime()
+	}
+	keys := make([]any, len(g.a.GroupBy))
+	var sb strings.Builder
+	for i, om := range g.a.GroupBy {
+		v, _ := g.t.Value(entry, StringValue(om[fieldName(om)]))
+		keys[i] = PlainValue(v)
+		sb.WriteString(StringValue(v))
+		sb.WriteByte(0xff)
+	}
+	key := sb.String()
+	values := make([]any, len(g.a.Values))
+	for i, om := range g.a.Values {
+		values[i], _ = g.t.Value(entry, StringValue(om[fieldName(om)]))
+	}
+
+	maxGroups := g.a.MaxGroups
+	if maxGroups <= 0 {
+		maxGroups = DefaultAggregateMaxGroups
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastAdd = now
+	if ts.After(g.maxSeen) {
+		g.maxSeen = ts
+	}
+
+	added := false
+	full := false
+	// Every window with a start in (ts - window, ts]
+	first := ts.Add(-g.a.Window).Truncate(g.slide).Add(g.slide)
+	for start := first; !start.After(ts); start = start.Add(g.slide) {
+		if start.Add(g.a.Window).Before(g.done) || start.Add(g.a.Window).Equal(g.done) {
+			continue
+		}
+		w := g.windows[start.UnixNano()]
+		if w == nil {
+			w = &aggWindow{start: start, groups: map[string]*aggGroup{}}
+			g.windows[start.UnixNano()] = w
+		}
+		grp := w.groups[key]
+		if grp == nil {
+			if len(w.order) >= maxGroups {
+				full = true
+				continue
+			}
+			grp = &aggGroup{keys: keys, first: ts, last: ts, values: make([]aggValue, len(values))}
+			w.groups[key] = grp
+			w.order = append(w.order, grp)
+		}
+		grp.add(ts, values)
+		added = true
+	}
+	switch {
+	case added:
+		return true, ""
+	case full:
+		return false, TooManyGroups
+	}
+	return false, LateEntry
+}
+
+func (grp *aggGroup) add(ts time.Time, values []any) {
+	grp.count++
+	if ts.Before(grp.first) {
+		grp.first = ts
+	}
+	if ts.After(grp.last) {
+		grp.last = ts
+	}
+	for i, v := range values {
+		f, ok := NumericValue(v)
+		if !ok || math.IsNaN(f) {
+			continue
+		}
+		av := &grp.values[i]
+		if av.n == 0 || f < av.min {
+			av.min = f
+		}
+		if av.n == 0 || f > av.max {
+			av.max = f
+		}
+		av.sum += f
+		av.n++
+	}
+}
+
+// Gives back the records for the windows that are over: their end is the
+// lateness behind the latest timestamp seen. That only goes by entry
+// timestamps, so it doesn't matter how far behind Cloud Logging is
+// delivering. Once nothing has come in for the lateness, the time since the
+// last entry counts too, so a quiet log's last window still gets output.
+func (g *Aggregator) Advance(now time.Time) []*Record {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	mark := g.maxSeen.Add(-g.lateness)
+	if idle := now.Sub(g.lastAdd); idle >= g.lateness {
+		mark = g.maxSeen.Add(idle - g.lateness)
+	}
+	return g.output(mark)
+}
+
+// Gives back the records for every window, over or not.
+func (g *Aggregator) Close() []*Record {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.output(time.Time{})
+}
+
+// Outputs the windows that end by mark, or all of them for a zero mark.
+func (g *Aggregator) output(mark time.Time) []*Record {
+	var ready []*aggWindow
+	for k, w := range g.windows {
+		end := w.start.Add(g.a.Window)
+		if mark.IsZero() || !end.After(mark) {
+			ready = append(ready, w)
+			delete(g.windows, k)
+			if end.After(g.done) {
+				g.done = end
+			}
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].start.Before(ready[j].start) })
+
+	var recs []*Record
+	for _, w := range ready {
+		for _, grp := range w.order {
+			recs = append(recs, g.record(w, grp))
+		}
+	}
+	return recs
+}
+
+func (g *Aggregator) record(w *aggWindow, grp *aggGroup) *Record {
+	rec := &Record{}
+	rec.Set("window-start", w.start.Format(time.RFC3339Nano))
+	rec.Set("window-end", w.start.Add(g.a.Window).Format(time.RFC3339Nano))
+	for i, om := range g.a.GroupBy {
+		rec.Set(fieldName(om), grp.keys[i])
+	}
+	rec.Set("count", grp.count)
+	rec.Set("first", grp.first.Format(time.RFC3339Nano))
+	rec.Set("last", grp.last.Format(time.RFC3339Nano))
+	for i, om := range g.a.Values {
+		name := fieldName(om)
+		av := grp.values[i]
+		if av.n == 0 {
+			rec.Set(name+"-min", nil)
+			rec.Set(name+"-max", nil)
+			rec.Set(name+"-avg", nil)
+			continue
+		}
+		rec.Set(name+"-min", av.min)
+		rec.Set(name+"-max", av.max)
+		rec.Set(name+"-avg", av.sum/float64(av.n))
+	}
+	if g.t.redactor != nil {
+		g.t.redact(rec)
+	}
+	return rec
+}
+
+// The names of the fields in the records, for CSV.
+func aggregateColumns(a *Aggregate) []string {
+	columns := []string{"window-start", "window-end"}
+	for _, om := range a.GroupBy {
+		columns = append(columns, fieldName(om))
+	}
+	columns = append(columns, "count", "first", "last")
+	for _, om := range a.Values {
+		name := fieldName(om)
+		columns = append(columns, name+"-min", name+"-max", name+"-avg")
+	}
+	return columns
+}
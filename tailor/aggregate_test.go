@@ -0,0 +1,147 @@
+package tailor
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var aggStart = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+
+func aggEntry(method string, at, latency time.Duration) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:     "projects/p/logs/app",
+		Timestamp:   timestamppb.New(aggStart.Add(at)),
+		Labels:      map[string]string{"method": method},
+		HttpRequest: &ltype.HttpRequest{Latency: durationpb.New(latency)},
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tr := newTestTailor(t, &Config{Aggregate: &Aggregate{
+		Window:    time.Minute,
+		GroupBy:   []OutputMap{{"method": "labels.method"}},
+		Values:    []OutputMap{{"latency": "httpRequest.latency"}},
+		MaxGroups: 2,
+	}})
+	g := NewAggregator(tr)
+	// Delivered well after they happened
+	arrived := aggStart.Add(10 * time.Minute)
+	for _, e := range []*logpb.LogEntry{
+		aggEntry("Get", 10*time.Second, time.Second),
+		aggEntry("Put", 20*time.Second, 4*time.Second),
+		aggEntry("Get", 5*time.Second, 3*time.Second),
+		aggEntry("Get", 62*time.Second, 2*time.Second),
+	} {
+		if ok, reason := g.addAt(e, arrived); !ok {
+			t.Fatalf("Add: %s", reason)
+		}
+	}
+	if ok, reason := g.addAt(aggEntry("Delete", 30*time.Second, 0), arrived); ok || reason != TooManyGroups {
+		t.Errorf("a third group: got %v, %q", ok, reason)
+	}
+
+	// Nothing's over until an entry's timestamp is the lateness past the end
+	// of the window, however late the clock is.
+	if recs := g.Advance(arrived); len(recs) != 0 {
+		t.Errorf("the window was output early: %v", recs)
+	}
+	g.addAt(aggEntry("Get", time.Minute+DefaultAggregateLateness, 0), arrived)
+	recs := g.Advance(arrived)
+	if len(recs) != 2 {
+		t.Fatalf("got %d records; want one per group", len(recs))
+	}
+	expected := []Field{
+		{Name: "window-start", Value: "2024-01-02T03:04:00Z"},
+		{Name: "window-end", Value: "2024-01-02T03:05:00Z"},
+		{Name: "method", Value: "Get"},
+		{Name: "count", Value: 2},
+		{Name: "first", Value: "2024-01-02T03:04:05Z"},
+		{Name: "last", Value: "2024-01-02T03:04:10Z"},
+		{Name: "latency-min", Value: 1.0},
+		{Name: "latency-max", Value: 3.0},
+		{Name: "latency-avg", Value: 2.0},
+	}
+	if !reflect.DeepEqual(recs[0].Fields, expected) {
+		t.Errorf("record = %v; want %v", recs[0].Fields, expected)
+	}
+	if !reflect.DeepEqual(fieldNames(recs[0]), tr.Columns()) {
+		t.Errorf("columns = %v; want %v", tr.Columns(), fieldNames(recs[0]))
+	}
+	if v, _ := recs[1].Get("method"); v != "Put" {
+		t.Errorf("second group = %v", v)
+	}
+
+	if ok, reason := g.Add(aggEntry("Get", 50*time.Second, 0)); ok || reason != LateEntry {
+		t.Errorf("an entry for a window that's out: got %v, %q", ok, reason)
+	}
+	recs = g.Close()
+	if len(recs) != 1 {
+		t.Fatalf("Close gave back %d records", len(recs))
+	}
+	if v, _ := recs[0].Get("count"); v != 2 {
+		t.Errorf("count = %v", v)
+	}
+}
+
+func TestAggregateIdle(t *testing.T) {
+	tr := newTestTailor(t, &Config{Aggregate: &Aggregate{Window: time.Minute}})
+	g := NewAggregator(tr)
+	now := time.Now()
+	g.addAt(aggEntry("Get", 10*time.Second, 0), now)
+
+	// Once it's quiet, the time since the last entry moves things along.
+	if recs := g.Advance(now.Add(DefaultAggregateLateness + 49*time.Second)); len(recs) != 0 {
+		t.Errorf("the window was output early: %v", recs)
+	}
+	if recs := g.Advance(now.Add(DefaultAggregateLateness + 50*time.Second)); len(recs) != 1 {
+		t.Errorf("got %d records after going quiet; want 1", len(recs))
+	}
+}
+
+func TestAggregateSliding(t *testing.T) {
+	tr := newTestTailor(t, &Config{Aggregate: &Aggregate{
+		Window: time.Minute,
+		Slide:  20 * time.Second,
+	}})
+	g := NewAggregator(tr)
+	g.Add(aggEntry("Get", 45*time.Second, 0))
+	var starts []string
+	for _, rec := range g.Close() {
+		v, _ := rec.Get("window-start")
+		starts = append(starts, strings.TrimPrefix(v.(string), "2024-01-02T03:"))
+	}
+	// Every window that has 03:04:45 in it
+	if expected := []string{"04:00Z", "04:20Z", "04:40Z"}; !reflect.DeepEqual(starts, expected) {
+		t.Errorf("window starts = %v", starts)
+	}
+}
+
+func TestPipeAggregate(t *testing.T) {
+	tr := newTestTailor(t, &Config{Aggregate: &Aggregate{
+		Window:  time.Minute,
+		GroupBy: []OutputMap{{"method": "labels.method"}},
+	}})
+	src := sliceSource{
+		aggEntry("Get", 0, 0),
+		aggEntry("Get", time.Second, 0),
+		aggEntry("Put", 2*time.Second, 0),
+	}
+	var out bytes.Buffer
+	if err := tr.Pipe(context.Background(), src, NewCSVSink(&out, tr.Columns())); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2024-01-02T03:04:00Z,2024-01-02T03:05:00Z,Get,2,2024-01-02T03:04:00Z,2024-01-02T03:04:01Z\n" +
+		"2024-01-02T03:04:00Z,2024-01-02T03:05:00Z,Put,1,2024-01-02T03:04:02Z,2024-01-02T03:04:02Z\n"
+	if out.String() != expected {
+		t.Errorf("Pipe wrote %q; want %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,268 @@
+package tailor
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+// Fires when more than threshold entries that match when come in within
+// the window for the same by values, like more than 5 SetIamPolicy calls
+// by one principal in 10 minutes. when is an expression on entry paths,
+// like protoPayload.methodName == "SetIamPolicy" and severity >= 500.
+type AlertRule struct {
+	Name string `yaml:"name"`
+	When string `yaml:"when"`
+	// Output names and paths. One count for each set of values.
+	By        []OutputMap   `yaml:"by"`
+	Threshold int           `yaml:"threshold"` // 0 fires on every match
+	Window    time.Duration `yaml:"window"`
+	// No new alert for the same rule and by values for this long after
+	// one. The window if 0.
+	Cooldown time.Duration `yaml:"cooldown"`
+	// Sets of by values counted at once. DefaultAlertMaxKeys if 0.
+	MaxKeys int `yaml:"max-keys"`
+}
+
+const DefaultAlertMaxKeys = 10000
+
+type alertRule struct {
+	def  AlertRule
+	when expr
+}
+
+func compileAlertRule(r AlertRule) (*alertRule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("alert rule needs a name")
+	}
+	if r.Threshold < 0 || r.Window < 0 || r.Cooldown < 0 {
+		return nil, fmt.Errorf("alert %s: threshold, window and cooldown can't be less than 0", r.Name)
+	}
+	if r.Threshold > 0 && r.Window == 0 {
+		return nil, fmt.Errorf("alert %s: a threshold needs a window", r.Name)
+	}
+	when, err := compileExpr(r.When)
+	if err != nil {
+		return nil, fmt.Errorf("alert %s: when: %v", r.Name, err)
+	}
+	for _, om := range r.By {
+		name := fieldName(om)
+		path, ok := om[name].(string)
+		if !ok {
+			return nil, fmt.Errorf("alert %s: by %s needs a path", r.Name, name)
+		}
+		if err := CheckPath(path); err != nil {
+			return nil, fmt.Errorf("alert %s: by %s: %v", r.Name, name, err)
+		}
+	}
+	return &alertRule{def: r, when: when}, nil
+}
+
+// Counts entries for the config's alert rules and makes alert records when
+// they fire. Like a Limiter, make one for each stream of entries. It's
+// safe for use by multiple goroutines.
+type Alerter struct {
+	t      *Tailor
+	mu     sync.Mutex
+	counts []map[string]*alertCount // by index in config.Alerts, then by values
+}
+
+type alertCount struct {
+	values []any
+	hits   []alertHit // the matches in the window
+	newest time.Time
+	fired  time.Time
+}
+
+type alertHit struct {
+	ts    time.Time
+	id    string // logName and insertId, to skip duplicates
+	fired bool   // in an alert already. Kept for the id.
+}
+
+func NewAlerter(t *Tailor) *Alerter {
+	a := &Alerter{t: t, counts: make([]map[string]*alertCount, len(t.alerts))}
+	for i := range a.counts {
+		a.counts[i] = map[string]*alertCount{}
+	}
+	return a
+}
+
+// An Alerter for t that keeps this one's counts, for when the config is
+// reloaded. A rule's windows and cooldowns carry over if t's config has a
+// rule with the same name, when and by.
+func (a *Alerter) Reload(t *Tailor) *Alerter {
+	na := NewAlerter(t)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, r := range a.t.alerts {
+		for j, nr := range t.alerts {
+			if nr.def.Name != r.def.Name {
+				continue
+			}
+			if nr.def.When == r.def.When && reflect.DeepEqual(nr.def.By, r.def.By) {
+				for k, c := range a.counts[i] {
+					nc := *c
+					nc.hits = slices.Clone(c.hits)
+					na.counts[j][k] = &nc
+				}
+			}
+			break
+		}
+	}
+	return na
+}
+
+// The alerts the entry sets off, redacted like any other record. Windows
+// and cooldowns go by entry timestamp. An entry that was already counted
+// (the same logName and insertId) isn't counted again.
+func (a *Alerter) Check(entry *logpb.LogEntry) []*Record {
+	if len(a.t.alerts) == 0 {
+		return nil
+	}
+	ts := time.Now()
+	if entry.Timestamp != nil {
+		ts = entry.Timestamp.AsTime()
+	}
+	var alerts []*Record
+	for i, rule := range a.t.alerts {
+		if !rule.when.eval(a.t, entry) {
+			continue
+		}
+		values := make([]any, len(rule.def.By))
+		var sb strings.Builder
+		for j, om := range rule.def.By {
+			v, _ := a.t.Value(entry, StringValue(om[fieldName(om)]))
+			values[j] = PlainValue(v)
+			sb.WriteString(StringValue(v))
+			sb.WriteByte(0xff)
+		}
+		if rec := a.count(i, rule, sb.String(), values, entry, ts); rec != nil {
+			if a.t.redactor != nil {
+				a.t.redact(rec)
+			}
+			alerts = append(alerts, rec)
+		}
+	}
+	return alerts
+}
+
+func (a *Alerter) count(i int, rule *alertRule, key string, values []any, entry *logpb.LogEntry, ts time.Time) *Record {
+	r := &rule.def
+	id := ""
+	if entry.InsertId != "" {
+		id = entry.LogName + "\x00" + entry.InsertId
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := a.counts[i]
+	c := counts[key]
+	if c == nil {
+		maxKeys := r.MaxKeys
+		if maxKeys <= 0 {
+			maxKeys = DefaultAlertMaxKeys
+		}
+		if len(counts) >= maxKeys {
+			pruneAlertCounts(counts, maxKeys, r, ts)
+		}
+		c = &alertCount{values: values}
+		counts[key] = c
+	}
+	for _, h := range c.hits {
+		if id != "" && h.id == id {
+			return nil
+		}
+	}
+	c.hits = append(c.hits, alertHit{ts: ts, id: id})
+	if ts.After(c.newest) {
+		c.newest = ts
+	}
+	if r.Window == 0 {
+		c.hits = c.hits[len(c.hits)-1:]
+	} else {
+		hits := c.hits[:0]
+		for _, h := range c.hits {
+			if h.ts.After(c.newest.Add(-r.Window)) {
+				hits = append(hits, h)
+			}
+		}
+		c.hits = hits
+	}
+
+	n := 0
+	for _, h := range c.hits {
+		if !h.fired {
+			n++
+		}
+	}
+	if n <= r.Threshold {
+		return nil
+	}
+	cooldown := r.Cooldown
+	if cooldown == 0 {
+		cooldown = r.Window
+	}
+	if !c.fired.IsZero() && ts.Sub(c.fired) < cooldown {
+		return nil
+	}
+	c.fired = ts
+	rec := alertRecord(r, c)
+	// The next alert only counts what comes after this one, but replays of
+	// these still get skipped while they're in the window.
+	for i := range c.hits {
+		c.hits[i].fired = true
+	}
+	return rec
+}
+
+// Makes room for new keys. The ones with nothing in the window and no
+// cooldown going go first. If that's not enough, they all go.
+func pruneAlertCounts(counts map[string]*alertCount, maxKeys int, r *AlertRule, now time.Time) {
+	for k, c := range counts {
+		if now.Sub(c.newest) >= r.Window && now.Sub(c.fired) >= max(r.Cooldown, r.Window) {
+			delete(counts, k)
+		}
+	}
+	if len(counts) >= maxKeys {
+		clear(counts)
+	}
+}
+
+// For the hits that haven't fired yet.
+func alertRecord(r *AlertRule, c *alertCount) *Record {
+	var first, last time.Time
+	count := 0
+	ids := []string{}
+	for _, h := range c.hits {
+		if h.fired {
+			continue
+		}
+		if count == 0 || h.ts.Before(first) {
+			first = h.ts
+		}
+		if count == 0 || h.ts.After(last) {
+			last = h.ts
+		}
+		count++
+		if _, insertID, ok := strings.Cut(h.id, "\x00"); ok {
+			ids = append(ids, insertID)
+		}
+	}
+	rec := &Record{}
+	rec.Set("alert", r.Name)
+	for i, om := range r.By {
+		rec.Set(fieldName(om), c.values[i])
+	}
+	rec.Set("count", count)
+	rec.Set("window", r.Window.String())
+	rec.Set("first", first.Format(time.RFC3339Nano))
+	rec.Set("last", last.Format(time.RFC3339Nano))
+	rec.Set("insert-ids", ids)
+	return rec
+}
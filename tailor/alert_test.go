@@ -0,0 +1,157 @@
+package tailor
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func auditEntry(method, principal, id string, at time.Duration) *logpb.LogEntry {
+	payload, _ := structpb.NewStruct(map[string]any{
+		"methodName":         method,
+		"authenticationInfo": map[string]any{"principalEmail": principal},
+	})
+	return &logpb.LogEntry{
+		LogName:   "projects/p/logs/cloudaudit.googleapis.com%2Factivity",
+		InsertId:  id,
+		Timestamp: timestamppb.New(aggStart.Add(at)),
+		Payload:   &logpb.LogEntry_JsonPayload{JsonPayload: payload},
+	}
+}
+
+func TestAlerter(t *testing.T) {
+	tr := newTestTailor(t, &Config{Alerts: []AlertRule{{
+		Name:      "iam-burst",
+		When:      `protoPayload.methodName == "SetIamPolicy"`,
+		By:        []OutputMap{{"principal": "protoPayload.authenticationInfo.principalEmail"}},
+		Threshold: 2,
+		Window:    10 * time.Minute,
+		Cooldown:  time.Hour,
+	}}})
+	a := NewAlerter(tr)
+	n := 0
+	check := func(method, principal string, at time.Duration) []*Record {
+		n++
+		return a.Check(auditEntry(method, principal, fmt.Sprint(n), at))
+	}
+
+	if alerts := check("SetIamPolicy", "alice", 0); len(alerts) != 0 {
+		t.Errorf("fired on the first call: %v", alerts)
+	}
+	check("GetIamPolicy", "alice", time.Minute) // doesn't match
+	check("SetIamPolicy", "bob", time.Minute)   // someone else
+	// A duplicate of the first one doesn't count.
+	a.Check(auditEntry("SetIamPolicy", "alice", "1", 0))
+	if alerts := check("SetIamPolicy", "alice", 2*time.Minute); len(alerts) != 0 {
+		t.Errorf("fired at the threshold: %v", alerts)
+	}
+	alerts := check("SetIamPolicy", "alice", 3*time.Minute)
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts; want 1 over the threshold", len(alerts))
+	}
+	expected := []Field{
+		{Name: "alert", Value: "iam-burst"},
+		{Name: "principal", Value: "alice"},
+		{Name: "count", Value: 3},
+		{Name: "window", Value: "10m0s"},
+		{Name: "first", Value: "2024-01-02T03:04:00Z"},
+		{Name: "last", Value: "2024-01-02T03:07:00Z"},
+		{Name: "insert-ids", Value: []string{"1", "4", "5"}},
+	}
+	if !reflect.DeepEqual(alerts[0].Fields, expected) {
+		t.Errorf("alert = %v; want %v", alerts[0].Fields, expected)
+	}
+
+	// In the cooldown, no more alerts for alice.
+	for i := 0; i < 5; i++ {
+		if alerts := check("SetIamPolicy", "alice", 4*time.Minute); len(alerts) != 0 {
+			t.Fatalf("fired in the cooldown: %v", alerts)
+		}
+	}
+	// Calls spread out more than the window don't add up.
+	for i := 0; i < 5; i++ {
+		if alerts := check("SetIamPolicy", "bob", time.Duration(i+2)*11*time.Minute); len(alerts) != 0 {
+			t.Fatalf("fired for calls outside the window: %v", alerts)
+		}
+	}
+	// After it, alice can set it off again.
+	var fired int
+	for i := 0; i < 3; i++ {
+		fired += len(check("SetIamPolicy", "alice", 70*time.Minute))
+	}
+	if fired != 1 {
+		t.Errorf("%d alerts after the cooldown; want 1", fired)
+	}
+}
+
+func TestAlerterReplays(t *testing.T) {
+	tr := newTestTailor(t, &Config{Alerts: []AlertRule{{
+		Name:      "iam-burst",
+		When:      `protoPayload.methodName == "SetIamPolicy"`,
+		Threshold: 2,
+		Window:    10 * time.Minute,
+		Cooldown:  time.Minute,
+	}}})
+	a := NewAlerter(tr)
+	fired := 0
+	for i := 0; i < 3; i++ {
+		fired += len(a.Check(auditEntry("SetIamPolicy", "alice", fmt.Sprint(i), time.Duration(i)*time.Minute)))
+	}
+	if fired != 1 {
+		t.Fatalf("%d alerts; want 1", fired)
+	}
+
+	// Entries that were in the alert come in again, like after a reconnect.
+	// They're still in the window, so they aren't counted again.
+	for i := 0; i < 3; i++ {
+		a.Check(auditEntry("SetIamPolicy", "alice", fmt.Sprint(i), time.Duration(i)*time.Minute))
+	}
+	if alerts := a.Check(auditEntry("SetIamPolicy", "alice", "3", 5*time.Minute)); len(alerts) != 0 {
+		t.Errorf("replays set it off again: %v", alerts)
+	}
+	// New ones do.
+	a.Check(auditEntry("SetIamPolicy", "alice", "4", 5*time.Minute))
+	alerts := a.Check(auditEntry("SetIamPolicy", "alice", "5", 5*time.Minute))
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts; want 1 for three new entries", len(alerts))
+	}
+	if ids, _ := alerts[0].Get("insert-ids"); !reflect.DeepEqual(ids, []string{"3", "4", "5"}) {
+		t.Errorf("insert-ids = %v", ids)
+	}
+}
+
+func TestAlertRedaction(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		Alerts: []AlertRule{{
+			Name: "iam",
+			When: `protoPayload.methodName == "SetIamPolicy"`,
+			By:   []OutputMap{{"principal": "protoPayload.authenticationInfo.principalEmail"}},
+		}},
+		Redact: &Redaction{Rules: []RedactRule{{Path: "principal", Action: "mask"}}},
+	})
+	alerts := NewAlerter(tr).Check(auditEntry("SetIamPolicy", "alice@example.com", "1", 0))
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts; want 1", len(alerts))
+	}
+	if v, _ := alerts[0].Get("principal"); v != Redacted {
+		t.Errorf("principal = %v; want it masked", v)
+	}
+}
+
+func TestBadAlertRules(t *testing.T) {
+	for _, r := range []AlertRule{
+		{When: `severity == "ERROR"`},
+		{Name: "no-when"},
+		{Name: "no-window", When: `severity == "ERROR"`, Threshold: 5},
+		{Name: "bad-by", When: `severity == "ERROR"`, By: []OutputMap{{"p": "principal"}}},
+	} {
+		if err := CheckAlertRule(r); err == nil {
+			t.Errorf("%+v should have failed", r)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package tailor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+// Checks for config parts, so problems can be found before New and
+// reported one by one.
+
+var logEntryType = reflect.TypeOf(logpb.LogEntry{})
+
+// Paths have to parse and start with something a LogEntry has.
+func CheckPath(path string) error {
+	elems, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(elems) == 0 {
+		return errors.New("empty path")
+	}
+	if elems[0].kind != keyElem {
+		return nil
+	}
+	key := elems[0].key
+	roots := []string{"protoPayload", "jsonPayload", "textPayload"}
+	for _, r := range roots {
+		if key == r {
+			return nil
+		}
+	}
+	if _, ok := logEntryType.FieldByName(capitalize(key)); ok {
+		return nil
+	}
+
+	for i := 0; i < logEntryType.NumField(); i++ {
+		if f := logEntryType.Field(i); f.IsExported() {
+			name := []rune(f.Name)
+			name[0] = unicode.ToLower(name[0])
+			roots = append(roots, string(name))
+		}
+	}
+	msg := fmt.Sprintf("%s doesn't start with a LogEntry field", path)
+	if s := Suggest(key, roots); s != "" {
+		msg += " (did you mean " + s + "?)"
+	}
+	return errors.New(msg)
+}
+
+func CheckTransforms(spec any) error {
+	_, err := compileTransforms(spec)
+	return err
+}
+
+func CheckParser(def ParserDef) error {
+	_, err := newTextParser(def)
+	return err
+}
+
+func CheckRedaction(r *Redaction) error {
+	_, err := newRedactor(r)
+	return err
+}
+
+func CheckSample(s *Sample) error {
+	if s.Ratio <= 0 || s.Ratio > 1 {
+		return fmt.Errorf("sample ratio %v isn't more than 0 and at most 1", s.Ratio)
+	}
+	if s.By != "" {
+		return CheckPath(s.By)
+	}
+	return nil
+}
+
+func CheckRateLimit(rl *RateLimit) error {
+	if rl.Rate <= 0 {
+		return fmt.Errorf("rate-limit rate %v isn't more than 0", rl.Rate)
+	}
+	if rl.Key != "" {
+		return CheckPath(rl.Key)
+	}
+	return nil
+}
+
+func CheckAggregate(a *Aggregate) error {
+	if a.Window <= 0 {
+		return fmt.Errorf("aggregate window %v isn't more than 0", a.Window)
+	}
+	if a.Slide < 0 || a.Slide > a.Window {
+		return fmt.Errorf("aggregate slide %v isn't between 0 and the window", a.Slide)
+	}
+	for _, oms := range [][]OutputMap{a.GroupBy, a.Values} {
+		for _, om := range oms {
+			name := fieldName(om)
+			path, ok := om[name].(string)
+			if !ok {
+				return fmt.Errorf("aggregate %s: needs a path", name)
+			}
+			if err := CheckPath(path); err != nil {
+				return fmt.Errorf("aggregate %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func CheckTraces(tc *Traces) error {
+	if tc.Idle < 0 {
+		return fmt.Errorf("traces idle %v is less than 0", tc.Idle)
+	}
+	if tc.Service != "" {
+		return CheckPath(tc.Service)
+	}
+	return nil
+}
+
+// Loads the table.
+func CheckEnrich(e Enrich) error {
+	_, err := newEnricher(e)
+	return err
+}
+
+// Reads the databases.
+func CheckGeoIP(g *GeoIP) error {
+	_, err := newGeoLocator(g)
+	return err
+}
+
+func CheckAlertRule(r AlertRule) error {
+	_, err := compileAlertRule(r)
+	return err
+}
+
+// Loads the script and runs its top level.
+func CheckScript(s *Script) error {
+	_, err := compileScript(s)
+	return err
+}
+
+// Finds the candidate that's the same apart from case, - and _ or that's
+// a typo or two away.
+func Suggest(key string, candidates []string) string {
+	norm := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+	}
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if norm(c) == norm(key) {
+			return c
+		}
+		if d := editDistance(key, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
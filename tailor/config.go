@@ -0,0 +1,54 @@
+package tailor
+
+// The fields for one output record, in order. Each map has one key: the
+// output name. Its value is an entry path, a map of more outputs or a
+// field spec (src plus regex/value, transform, missing or default).
+type OutputMap map[string]any
+
+// How entries get tailored. It's the part of the log-tailor YAML config
+// that isn't about where entries come from or where they go.
+type Config struct {
+	MatchRule string      `yaml:"match-rule"`
+	Common    []OutputMap `yaml:"common-output"`
+	Logs      []Log       `yaml:"logs"`
+	// Masking, hashing and dropping of sensitive values in the output.
+	Redact *Redaction `yaml:"redact"`
+	// What to do when an output has no value: null (the default), omit,
+	// default (use missing-default) or fail (drop the entry).
+	Missing        string `yaml:"missing"`
+	MissingDefault any    `yaml:"missing-default"`
+	// Parsers for strings (like textPayload) that have structure in them.
+	Parsers []ParserDef `yaml:"parsers"`
+	// Extra protobuf types for decoding protoPayloads.
+	Protos *Protos `yaml:"protos"`
+	// Columns from local tables, added to every record before the script.
+	Enrich []Enrich `yaml:"enrich"`
+	// Where IPs are from, added after the enrich: columns.
+	GeoIP *GeoIP `yaml:"geoip"`
+	// Rules that make alert records (see Alerter).
+	Alerts []AlertRule `yaml:"alerts"`
+	// Reshapes records for logs that don't have their own script.
+	Script *Script `yaml:"script"`
+	// Output rollups of the entries instead of the entries (see
+	// NewCollector).
+	Aggregate *Aggregate `yaml:"aggregate"`
+	Traces    *Traces    `yaml:"traces"`
+}
+
+type Log struct {
+	Name    string      `yaml:"name"`
+	ResType string      `yaml:"type"`
+	Output  []OutputMap `yaml:"output"`
+	Script  *Script     `yaml:"script"`
+	// Keep only some of the log's entries (see Limiter).
+	Sample    *Sample    `yaml:"sample"`
+	RateLimit *RateLimit `yaml:"rate-limit"`
+}
+
+func ValidMissingPolicy(p string) bool {
+	switch p {
+	case "", "null", "omit", "default", "fail":
+		return true
+	}
+	return false
+}
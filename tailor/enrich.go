@@ -0,0 +1,237 @@
+package tailor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"gopkg.in/yaml.v3"
+)
+
+// Adds columns from a local table to records, looked up by an entry
+// path. The file is CSV with a header row, or JSON or YAML with a list of
+// objects or an object of objects by key. It's reread when it changes.
+type Enrich struct {
+	File string `yaml:"file"`
+	// The entry path to look up and the table column it's matched with.
+	On  string `yaml:"on"`
+	Key string `yaml:"key"`
+	// The columns to add. All but the key if empty.
+	Columns []string `yaml:"columns"`
+	// How often to check the file for changes. DefaultEnrichReload if 0.
+	Reload time.Duration `yaml:"reload"`
+}
+
+const DefaultEnrichReload = time.Minute
+
+type enricher struct {
+	def    Enrich
+	reload time.Duration
+	table  atomic.Pointer[lookupTable]
+	// When the file was last checked, in UnixNano. A check holds mu.
+	checked atomic.Int64
+	mu      sync.Mutex
+}
+
+type lookupTable struct {
+	rows    map[string]map[string]any // by key
+	columns []string                  // in file order, or sorted for JSON and YAML
+	modTime time.Time
+	size    int64
+}
+
+// Loads the table.
+func newEnricher(def Enrich) (*enricher, error) {
+	if def.File == "" || def.On == "" || def.Key == "" {
+		return nil, errors.New("enrich needs a file, on and key")
+	}
+	if err := CheckPath(def.On); err != nil {
+		return nil, fmt.Errorf("enrich %s: %v", def.File, err)
+	}
+	e := &enricher{def: def, reload: def.Reload}
+	if e.reload <= 0 {
+		e.reload = DefaultEnrichReload
+	}
+	table, err := loadTable(def.File, def.Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range def.Columns {
+		if !slices.Contains(table.columns, c) {
+			return nil, fmt.Errorf("enrich %s: no column %s", def.File, c)
+		}
+	}
+	e.table.Store(table)
+	e.checked.Store(time.Now().UnixNano())
+	return e, nil
+}
+
+// The names of the fields it adds.
+func (e *enricher) columns() []string {
+	if len(e.def.Columns) > 0 {
+		return e.def.Columns
+	}
+	var columns []string
+	for _, c := range e.table.Load().columns {
+		if c != e.def.Key {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// Adds the row for the entry to the record. The columns are null if
+// there's no row for it.
+func (e *enricher) apply(t *Tailor, entry *logpb.LogEntry, memo parseMemo, rec *Record, now time.Time) {
+	e.maybeReload(t, now)
+	v, _ := t.value(entry, e.def.On, memo)
+	var row map[string]any
+	if v != nil {
+		row = e.table.Load().rows[StringValue(v)]
+	}
+	for _, c := range e.columns() {
+		rec.Set(c, row[c])
+	}
+}
+
+// Rereads the file if it's time to look and it changed. Only one caller
+// does it; the others keep using the table they have. If the new one
+// can't be loaded, the old one stays and the error goes to OnError.
+func (e *enricher) maybeReload(t *Tailor, now time.Time) {
+	if now.UnixNano()-e.checked.Load() < int64(e.reload) || !e.mu.TryLock() {
+		return
+	}
+	defer e.mu.Unlock()
+	e.checked.Store(now.UnixNano())
+
+	old := e.table.Load()
+	info, err := os.Stat(e.def.File)
+	if err != nil {
+		t.report(fmt.Errorf("enrich: %v", err))
+		return
+	}
+	if info.ModTime().Equal(old.modTime) && info.Size() == old.size {
+		return
+	}
+	table, err := loadTable(e.def.File, e.def.Key)
+	if err != nil {
+		t.report(err)
+		return
+	}
+	e.table.Store(table)
+}
+
+func loadTable(file, key string) (*lookupTable, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("enrich: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("enrich: %v", err)
+	}
+	table := &lookupTable{rows: map[string]map[string]any{}, modTime: info.ModTime(), size: info.Size()}
+
+	var rows []map[string]any
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("enrich %s: %v", file, err)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("enrich %s: no header row", file)
+		}
+		table.columns = records[0]
+		for _, r := range records[1:] {
+			row := make(map[string]any, len(r))
+			for i, v := range r {
+				row[table.columns[i]] = v
+			}
+			rows = append(rows, row)
+		}
+	case ".json", ".yaml", ".yml":
+		var doc any
+		if ext == ".json" {
+			err = json.Unmarshal(data, &doc)
+		} else {
+			err = yaml.Unmarshal(data, &doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("enrich %s: %v", file, err)
+		}
+		if rows, err = tableRows(doc, key); err != nil {
+			return nil, fmt.Errorf("enrich %s: %v", file, err)
+		}
+		seen := map[string]bool{}
+		for _, row := range rows {
+			for c := range row {
+				if !seen[c] {
+					seen[c] = true
+					table.columns = append(table.columns, c)
+				}
+			}
+		}
+		sort.Strings(table.columns)
+	default:
+		return nil, fmt.Errorf("enrich %s: unknown file type %q (csv, json or yaml)", file, ext)
+	}
+
+	if !slices.Contains(table.columns, key) {
+		return nil, fmt.Errorf("enrich %s: no %s column", file, key)
+	}
+	for i, row := range rows {
+		k, ok := row[key]
+		if !ok || k == nil {
+			return nil, fmt.Errorf("enrich %s: row %d has no %s", file, i+1, key)
+		}
+		table.rows[StringValue(k)] = row
+	}
+	return table, nil
+}
+
+// The rows in a list of objects or an object of objects. For the latter
+// the object's key is the row's key.
+func tableRows(doc any, key string) ([]map[string]any, error) {
+	var rows []map[string]any
+	switch doc := doc.(type) {
+	case []any:
+		for i, v := range doc {
+			row, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("row %d isn't an object", i+1)
+			}
+			rows = append(rows, row)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(doc))
+		for k := range doc {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			row, ok := doc[k].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("row %s isn't an object", k)
+			}
+			if _, ok := row[key]; !ok {
+				row[key] = k
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, errors.New("not a list or object of rows")
+	}
+	return rows, nil
+}
@@ -0,0 +1,126 @@
+package tailor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+func projectEntry(project string) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:  "projects/p/logs/app",
+		InsertId: "1",
+		Resource: &mrpb.MonitoredResource{Labels: map[string]string{"project_id": project}},
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"teams.csv": "project_id,team,cost-center\nweb-prod,web,cc-1\ndata-prod,data,cc-2\n",
+		"teams.json": `[{"project_id": "web-prod", "team": "web", "cost-center": "cc-1"},
+			{"project_id": "data-prod", "team": "data", "cost-center": "cc-2"}]`,
+		"teams.yaml": "web-prod: {team: web, cost-center: cc-1}\ndata-prod: {team: data, cost-center: cc-2}\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name := range files {
+		tr := newTestTailor(t, &Config{
+			Common: []OutputMap{{"id": "insertId"}},
+			Enrich: []Enrich{{File: filepath.Join(dir, name), On: "resource.labels.project_id", Key: "project_id", Columns: []string{"team", "cost-center"}}},
+		})
+		rec, err := tr.Record(projectEntry("data-prod"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []Field{{Name: "id", Value: "1"}, {Name: "team", Value: "data"}, {Name: "cost-center", Value: "cc-2"}}
+		if !reflect.DeepEqual(rec.Fields, expected) {
+			t.Errorf("%s: record = %v; want %v", name, rec.Fields, expected)
+		}
+		if !reflect.DeepEqual(tr.Columns(), fieldNames(rec)) {
+			t.Errorf("%s: columns = %v", name, tr.Columns())
+		}
+
+		rec, _ = tr.Record(projectEntry("nope"))
+		if v, ok := rec.Get("team"); !ok || v != nil {
+			t.Errorf("%s: team for a project that's not in the table = %v, %v", name, v, ok)
+		}
+	}
+
+	// All the columns but the key, in file order
+	tr := newTestTailor(t, &Config{
+		Common: []OutputMap{{"id": "insertId"}},
+		Enrich: []Enrich{{File: filepath.Join(dir, "teams.csv"), On: "resource.labels.project_id", Key: "project_id"}},
+	})
+	if columns := tr.Columns(); !reflect.DeepEqual(columns, []string{"id", "team", "cost-center"}) {
+		t.Errorf("columns = %v", columns)
+	}
+}
+
+func TestEnrichReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "teams.csv")
+	if err := os.WriteFile(file, []byte("project_id,team\nweb-prod,web\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTailor(t, &Config{Enrich: []Enrich{{File: file, On: "resource.labels.project_id", Key: "project_id", Reload: time.Minute}}})
+	var errs []error
+	tr.OnError = func(err error) { errs = append(errs, err) }
+	e := tr.enrichers[0]
+	team := func(now time.Time) any {
+		rec := &Record{}
+		e.apply(tr, projectEntry("web-prod"), nil, rec, now)
+		v, _ := rec.Get("team")
+		return v
+	}
+
+	if err := os.WriteFile(file, []byte("project_id,team\nweb-prod,frontend\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(file, later, later)
+	if v := team(time.Now()); v != "web" {
+		t.Errorf("team = %v; the file shouldn't be checked until reload has passed", v)
+	}
+	now := time.Now().Add(2 * time.Minute)
+	if v := team(now); v != "frontend" {
+		t.Errorf("team = %v after the file changed", v)
+	}
+
+	// A bad file leaves the table as it was.
+	if err := os.WriteFile(file, []byte("team\nweb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, later.Add(time.Hour), later.Add(time.Hour))
+	if v := team(now.Add(2 * time.Minute)); v != "frontend" || len(errs) != 1 {
+		t.Errorf("team = %v, errors %v after a bad reload", v, errs)
+	}
+}
+
+func TestBadEnrich(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"nokey.csv":  "team\nweb\n",
+		"list.json":  `[1, 2]`,
+		"table.xlsx": "",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckEnrich(Enrich{File: file, On: "resource.labels.project_id", Key: "project_id"}); err == nil {
+			t.Errorf("%s should have failed", name)
+		}
+	}
+	if err := CheckEnrich(Enrich{File: filepath.Join(dir, "nokey.csv"), On: "labels.x", Key: "team", Columns: []string{"owner"}}); err == nil {
+		t.Errorf("a column that's not in the table should fail")
+	}
+}
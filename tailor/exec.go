@@ -0,0 +1,285 @@
+package tailor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The exec sink protocol. The command is started by Open and records are
+// written to its stdin as frames:
+//
+//	{"seq": 1, "record": {...}}
+//
+// With jsonl framing each frame is a line of JSON. With protobuf framing
+// it's a google.protobuf.Struct with the same fields, preceded by its
+// length as a 4-byte big-endian number. For every frame the process
+// writes an ack to its stdout, framed the same way:
+//
+//	{"seq": 1}                     written
+//	{"seq": 1, "error": "..."}     not written, and why
+//
+// Acks can come in any order. Whatever the process writes to stderr goes
+// to our stderr.
+const (
+	JSONLFraming    = "jsonl"
+	ProtobufFraming = "protobuf"
+)
+
+const DefaultMaxInFlight = 100
+
+// The biggest ack we'll read. A process that sends a bigger one is treated
+// as gone, rather than trusting its length prefix with our memory.
+const MaxAckSize = 1 << 20
+
+// Streams records to a process. Set the fields before calling Open.
+type ExecSink struct {
+	Command []string
+	Framing string // JSONLFraming (the default) or ProtobufFraming
+	// Records written but not acked yet. Write waits when there are this
+	// many. DefaultMaxInFlight if 0.
+	MaxInFlight int
+	// Where the process's stderr goes. os.Stderr if nil.
+	Stderr io.Writer
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	readers sync.WaitGroup
+
+	mu  sync.Mutex // for writing frames
+	w   *bufio.Writer
+	seq uint64
+
+	ackMU   sync.Mutex
+	acked   *sync.Cond
+	pending map[uint64]bool
+	nack    error // the first error acked since the last Flush
+	dead    error // the process can't take any more records
+}
+
+func NewExecSink(command []string, framing string) *ExecSink {
+	return &ExecSink{Command: command, Framing: framing}
+}
+
+func (s *ExecSink) Open() error {
+	if len(s.Command) == 0 {
+		return errors.New("exec sink: no command")
+	}
+	switch s.Framing {
+	case "", JSONLFraming, ProtobufFraming:
+	default:
+		return fmt.Errorf("exec sink: unknown framing %q (%s or %s)", s.Framing, JSONLFraming, ProtobufFraming)
+	}
+
+	s.cmd = exec.Command(s.Command[0], s.Command[1:]...)
+	s.cmd.Stderr = s.Stderr
+	if s.cmd.Stderr == nil {
+		s.cmd.Stderr = os.Stderr
+	}
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := s.cmd.Start(); err != nil {
+		return fmt.Errorf("exec sink: %v", err)
+	}
+
+	s.stdin = stdin
+	s.w = bufio.NewWriter(stdin)
+	s.acked = sync.NewCond(&s.ackMU)
+	s.pending = map[uint64]bool{}
+	s.readers.Add(1)
+	go s.readAcks(stdout)
+	return nil
+}
+
+// Sends the record. It can still be refused; that comes back from Flush.
+func (s *ExecSink) Write(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	frame, err := s.encode(s.seq, rec)
+	if err != nil {
+		return err
+	}
+	max := s.MaxInFlight
+	if max <= 0 {
+		max = DefaultMaxInFlight
+	}
+	if err := s.waitFor(func() bool { return len(s.pending) < max }); err != nil {
+		return err
+	}
+
+	s.ackMU.Lock()
+	s.pending[s.seq] = true
+	s.ackMU.Unlock()
+	if _, err := s.w.Write(frame); err != nil {
+		return s.failed(err)
+	}
+	return nil
+}
+
+// Waits until everything written has been acked. Gives back the first
+// error the process acked since the last Flush.
+func (s *ExecSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.waitFor(func() bool { return len(s.pending) == 0 }); err != nil {
+		return err
+	}
+	s.ackMU.Lock()
+	defer s.ackMU.Unlock()
+	err := s.nack
+	s.nack = nil
+	return err
+}
+
+// Flushes, closes the process's stdin and waits for it to exit.
+func (s *ExecSink) Close() error {
+	if s.cmd == nil {
+		return nil
+	}
+	err := s.Flush()
+	s.stdin.Close()
+	s.readers.Wait()
+	if werr := s.cmd.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("exec sink: %v", werr)
+	}
+	return err
+}
+
+// Sends what's buffered and waits until done is true or the process is
+// gone. Call it with mu held.
+func (s *ExecSink) waitFor(done func() bool) error {
+	if err := s.w.Flush(); err != nil {
+		return s.failed(err)
+	}
+	s.ackMU.Lock()
+	defer s.ackMU.Unlock()
+	for !done() && s.dead == nil {
+		s.acked.Wait()
+	}
+	return s.dead
+}
+
+func (s *ExecSink) failed(err error) error {
+	s.ackMU.Lock()
+	defer s.ackMU.Unlock()
+	if s.dead == nil {
+		s.dead = fmt.Errorf("exec sink: %v", err)
+		s.acked.Broadcast()
+	}
+	return s.dead
+}
+
+func (s *ExecSink) encode(seq uint64, rec *Record) ([]byte, error) {
+	frame, err := json.Marshal(map[string]any{"seq": seq, "record": rec.Map()})
+	if err != nil {
+		return nil, err
+	}
+	if s.Framing != ProtobufFraming {
+		return append(frame, '\n'), nil
+	}
+
+	var st structpb.Struct
+	if err := protojson.Unmarshal(frame, &st); err != nil {
+		return nil, err
+	}
+	msg, err := proto.Marshal(&st)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...), nil
+}
+
+type execAck struct {
+	Seq   uint64 `json:"seq"`
+	Error string `json:"error"`
+}
+
+func (s *ExecSink) readAcks(stdout io.Reader) {
+	defer s.readers.Done()
+	r := bufio.NewReader(stdout)
+	for {
+		ack, err := s.readAck(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("the process closed its stdout")
+			}
+			s.failed(err)
+			io.Copy(io.Discard, r)
+			return
+		}
+
+		s.ackMU.Lock()
+		if s.pending[ack.Seq] {
+			delete(s.pending, ack.Seq)
+			if ack.Error != "" && s.nack == nil {
+				s.nack = fmt.Errorf("exec sink: record %d: %s", ack.Seq, ack.Error)
+			}
+			s.acked.Broadcast()
+		}
+		s.ackMU.Unlock()
+	}
+}
+
+func (s *ExecSink) readAck(r *bufio.Reader) (*execAck, error) {
+	ack := &execAck{}
+	if s.Framing != ProtobufFraming {
+		var line []byte
+		for {
+			part, err := r.ReadSlice('\n')
+			if len(line)+len(part) > MaxAckSize {
+				return nil, fmt.Errorf("an ack is over %d bytes", MaxAckSize)
+			}
+			line = append(line, part...)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, bufio.ErrBufferFull) {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(line, ack); err != nil {
+			return nil, fmt.Errorf("bad ack %q: %v", line, err)
+		}
+		return ack, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxAckSize {
+		return nil, fmt.Errorf("an ack of %d bytes is over %d", n, MaxAckSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	var st structpb.Struct
+	if err := proto.Unmarshal(msg, &st); err != nil {
+		return nil, fmt.Errorf("bad ack: %v", err)
+	}
+	fields := st.GetFields()
+	ack.Seq = uint64(fields["seq"].GetNumberValue())
+	ack.Error = fields["error"].GetStringValue()
+	return ack, nil
+}
@@ -0,0 +1,172 @@
+package tailor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Not a real test. It's the process the exec sink tests run: it acks
+// every record (refusing the ones with a "bad" field) and writes the
+// records it got to $EXEC_SINK_OUT as JSON lines.
+func TestExecSinkProcess(t *testing.T) {
+	framing := os.Getenv("EXEC_SINK_FRAMING")
+	if framing == "" {
+		return
+	}
+	out, err := os.Create(os.Getenv("EXEC_SINK_OUT"))
+	if err != nil {
+		os.Exit(2)
+	}
+	defer os.Exit(0)
+	defer out.Close()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		var frame map[string]any
+		if framing == JSONLFraming {
+			line, err := in.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			json.Unmarshal(line, &frame)
+		} else {
+			var size [4]byte
+			if _, err := io.ReadFull(in, size[:]); err != nil {
+				return
+			}
+			msg := make([]byte, binary.BigEndian.Uint32(size[:]))
+			io.ReadFull(in, msg)
+			var st structpb.Struct
+			proto.Unmarshal(msg, &st)
+			frame = st.AsMap()
+		}
+
+		record := frame["record"].(map[string]any)
+		line, _ := json.Marshal(record)
+		out.Write(append(line, '\n'))
+
+		ack := map[string]any{"seq": frame["seq"]}
+		if _, bad := record["bad"]; bad {
+			ack["error"] = "bad record"
+		}
+		if framing == JSONLFraming {
+			line, _ := json.Marshal(ack)
+			os.Stdout.Write(append(line, '\n'))
+		} else {
+			st, _ := structpb.NewStruct(ack)
+			msg, _ := proto.Marshal(st)
+			os.Stdout.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...))
+		}
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	for _, framing := range []string{JSONLFraming, ProtobufFraming} {
+		outFile := filepath.Join(t.TempDir(), "out.jsonl")
+		t.Setenv("EXEC_SINK_FRAMING", framing)
+		t.Setenv("EXEC_SINK_OUT", outFile)
+
+		sink := NewExecSink([]string{os.Args[0], "-test.run=^TestExecSinkProcess$"}, framing)
+		sink.MaxInFlight = 2
+		if err := sink.Open(); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if err := sink.Write(&Record{Fields: []Field{{Name: "id", Value: id}}}); err != nil {
+				t.Fatalf("%s: %v", framing, err)
+			}
+		}
+		if err := sink.Flush(); err != nil {
+			t.Errorf("%s: %v", framing, err)
+		}
+
+		sink.Write(&Record{Fields: []Field{{Name: "bad", Value: true}}})
+		if err := sink.Flush(); err == nil || !strings.Contains(err.Error(), "bad record") {
+			t.Errorf("%s: expected the refused record's error, got %v", framing, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Errorf("%s: %v", framing, err)
+		}
+
+		got, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"id":"1"}` + "\n" + `{"id":"2"}` + "\n" + `{"id":"3"}` + "\n" + `{"bad":true}` + "\n"
+		if string(got) != expected {
+			t.Errorf("%s: the process got %q; want %q", framing, got, expected)
+		}
+	}
+}
+
+func TestExecSinkProcessGone(t *testing.T) {
+	sink := NewExecSink([]string{"true"}, JSONLFraming)
+	if err := sink.Open(); err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(&Record{Fields: []Field{{Name: "id", Value: "1"}}})
+	if err := sink.Flush(); err == nil {
+		t.Errorf("expected an error when the process isn't there to ack")
+	}
+	sink.Close()
+
+	if err := NewExecSink(nil, "").Open(); err == nil {
+		t.Errorf("expected an error with no command")
+	}
+	if err := NewExecSink([]string{"cat"}, "xml").Open(); err == nil {
+		t.Errorf("expected an error for an unknown framing")
+	}
+}
+
+// A process that sends an ack that's too big is gone.
+func TestExecSinkAckTooBig(t *testing.T) {
+	for framing, script := range map[string]string{
+		ProtobufFraming: `printf '\377\377\377\377'; cat >/dev/null`,
+		JSONLFraming:    `head -c 2000000 /dev/zero | tr '\0' x; cat >/dev/null`,
+	} {
+		sink := NewExecSink([]string{"sh", "-c", script}, framing)
+		if err := sink.Open(); err != nil {
+			t.Fatal(err)
+		}
+		sink.Write(&Record{Fields: []Field{{Name: "id", Value: "1"}}})
+		if err := sink.Flush(); err == nil || !strings.Contains(err.Error(), "over") {
+			t.Errorf("%s: expected the ack to be too big, got %v", framing, err)
+		}
+		if err := sink.Write(&Record{}); err == nil {
+			t.Errorf("%s: the process should be treated as gone", framing)
+		}
+		sink.Close()
+	}
+}
+
+// The frames are plain protobuf so other languages can read them.
+func TestExecSinkFrame(t *testing.T) {
+	sink := NewExecSink([]string{"cat"}, ProtobufFraming)
+	frame, err := sink.encode(7, &Record{Fields: []Field{{Name: "n", Value: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.BigEndian.Uint32(frame); int(size) != len(frame)-4 {
+		t.Fatalf("length prefix %d for %d bytes", size, len(frame)-4)
+	}
+	var st structpb.Struct
+	if err := proto.Unmarshal(frame[4:], &st); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := protojson.Marshal(&st)
+	var m map[string]any
+	json.Unmarshal(got, &m)
+	if m["seq"] != 7.0 || m["record"].(map[string]any)["n"] != 1.0 {
+		t.Errorf("frame = %s", got)
+	}
+}
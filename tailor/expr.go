@@ -0,0 +1,362 @@
+package tailor
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Conditions on entry paths, for alert rules:
+//
+//	protoPayload.methodName == "SetIamPolicy" and not protoPayload.status.code
+//	httpRequest.status >= 500 or severity == "ERROR"
+//	protoPayload.authenticationInfo.principalEmail =~ "@example\.com$"
+//
+// The comparisons are ==, !=, =~ and !~ (regexes), and <, <=, > and >=
+// (numbers; enums like severity go by their number). A path on its own is
+// true if it has a value that isn't null, false or "". A path that fans
+// out (like [*]) is true if any of its values is. Strings can be in
+// double or single quotes; numbers, true, false and null are literals.
+type expr interface {
+	eval(t *Tailor, entry *logpb.LogEntry) bool
+}
+
+type andExpr struct{ l, r expr }
+type orExpr struct{ l, r expr }
+type notExpr struct{ x expr }
+
+type cmpExpr struct {
+	path string
+	fan  bool // the path gives back a list to look through
+	op   string
+	lit  any // string, float64, bool or nil
+	re   *regexp.Regexp
+}
+
+func (e *andExpr) eval(t *Tailor, entry *logpb.LogEntry) bool {
+	return e.l.eval(t, entry) && e.r.eval(t, entry)
+}
+
+func (e *orExpr) eval(t *Tailor, entry *logpb.LogEntry) bool {
+	return e.l.eval(t, entry) || e.r.eval(t, entry)
+}
+
+func (e *notExpr) eval(t *Tailor, entry *logpb.LogEntry) bool {
+	return !e.x.eval(t, entry)
+}
+
+func (e *cmpExpr) eval(t *Tailor, entry *logpb.LogEntry) bool {
+	v, err := t.Value(entry, e.path)
+	if err != nil {
+		v = nil
+	}
+	if list, ok := v.([]any); ok && e.fan {
+		for _, item := range list {
+			if e.test(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return e.test(v)
+}
+
+func (e *cmpExpr) test(v any) bool {
+	switch e.op {
+	case "":
+		switch v := v.(type) {
+		case nil:
+			return false
+		case bool:
+			return v
+		case string:
+			return v != ""
+		}
+		return true
+	case "=~":
+		return v != nil && e.re.MatchString(StringValue(v))
+	case "!~":
+		return v == nil || !e.re.MatchString(StringValue(v))
+	case "==", "!=":
+		return equalLiteral(v, e.lit) == (e.op == "==")
+	}
+	n, ok := exprNumber(v)
+	lit, _ := e.lit.(float64)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return n < lit
+	case "<=":
+		return n <= lit
+	case ">":
+		return n > lit
+	}
+	return n >= lit
+}
+
+func equalLiteral(v, lit any) bool {
+	switch lit := lit.(type) {
+	case nil:
+		return v == nil
+	case float64:
+		n, ok := exprNumber(v)
+		return ok && n == lit
+	case bool:
+		b, ok := v.(bool)
+		return ok && b == lit
+	}
+	return v != nil && StringValue(v) == lit.(string)
+}
+
+func exprNumber(v any) (float64, bool) {
+	if e, ok := v.(protoreflect.Enum); ok {
+		return float64(e.Number()), true
+	}
+	return NumericValue(v)
+}
+
+func compileExpr(s string) (expr, error) {
+	p := &exprParser{src: s}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	if len(p.toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %s in %q", p.toks[p.pos].text, s)
+	}
+	return e, nil
+}
+
+type tokKind int
+
+const (
+	pathTok tokKind = iota
+	opTok
+	litTok
+	parenTok
+)
+
+type exprTok struct {
+	kind tokKind
+	text string
+	lit  any
+}
+
+type exprParser struct {
+	src  string
+	toks []exprTok
+	pos  int
+}
+
+var exprOps = []string{"==", "!=", "=~", "!~", "<=", ">=", "<", ">"}
+
+func (p *exprParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			p.toks = append(p.toks, exprTok{kind: parenTok, text: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return err
+			}
+			p.toks = append(p.toks, exprTok{kind: litTok, text: s[i : i+n], lit: str})
+			i += n
+		case strings.ContainsRune("=!<>", rune(c)):
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("bad operator at %q", s[i:])
+			}
+			p.toks = append(p.toks, exprTok{kind: opTok, text: op})
+			i += len(op)
+		default:
+			word := lexWord(s[i:])
+			if word == "" {
+				return fmt.Errorf("unexpected %q", s[i:])
+			}
+			p.toks = append(p.toks, wordTok(word))
+			i += len(word)
+		}
+	}
+	return nil
+}
+
+// A quoted string and how long it was with the quotes.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			// \" and \\ are the quote and backslash; anything else (like
+			// \. in a regex) stays as it is.
+			if i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\') {
+				i++
+			} else {
+				sb.WriteByte('\\')
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return "", 0, fmt.Errorf("unterminated string %s", s)
+}
+
+// A path, keyword or number: everything up to a space, an operator or a
+// paren, with key(...) and [...] taken whole.
+func lexWord(s string) string {
+	i := 0
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "key("):
+			end := strings.IndexByte(s[i:], ')')
+			if end < 0 {
+				return s
+			}
+			i += end + 1
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return s
+			}
+			i += end + 1
+		case unicode.IsSpace(rune(s[i])) || strings.ContainsRune("()=!<>\"'", rune(s[i])):
+			return s[:i]
+		default:
+			i++
+		}
+	}
+	return s
+}
+
+func wordTok(word string) exprTok {
+	switch word {
+	case "and", "or", "not":
+		return exprTok{kind: opTok, text: word}
+	case "true", "false":
+		return exprTok{kind: litTok, text: word, lit: word == "true"}
+	case "null":
+		return exprTok{kind: litTok, text: word}
+	}
+	if n, err := strconv.ParseFloat(word, 64); err == nil {
+		return exprTok{kind: litTok, text: word, lit: n}
+	}
+	return exprTok{kind: pathTok, text: word}
+}
+
+func (p *exprParser) peek(kind tokKind, text string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == kind && p.toks[p.pos].text == text
+}
+
+func (p *exprParser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.peek(opTok, "or") {
+		p.pos++
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = &orExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) and() (expr, error) {
+	l, err := p.unary()
+	for err == nil && p.peek(opTok, "and") {
+		p.pos++
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = &andExpr{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.peek(opTok, "not") {
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+	if p.peek(parenTok, "(") {
+		p.pos++
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(parenTok, ")") {
+			return nil, fmt.Errorf("missing ) in %q", p.src)
+		}
+		p.pos++
+		return x, nil
+	}
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("%q ends too soon", p.src)
+	}
+	tok := p.toks[p.pos]
+	if tok.kind != pathTok {
+		return nil, fmt.Errorf("expected a path, got %s", tok.text)
+	}
+	p.pos++
+	if err := CheckPath(tok.text); err != nil {
+		return nil, err
+	}
+	elems, _ := parsePath(tok.text)
+	e := &cmpExpr{path: tok.text, fan: hasFanOut(elems)}
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != opTok || !slices.Contains(exprOps, p.toks[p.pos].text) {
+		return e, nil
+	}
+	e.op = p.toks[p.pos].text
+	p.pos++
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != litTok {
+		return nil, fmt.Errorf("%s %s needs a value", e.path, e.op)
+	}
+	e.lit = p.toks[p.pos].lit
+	p.pos++
+	var err error
+	switch e.op {
+	case "=~", "!~":
+		s, ok := e.lit.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s %s needs a regex in quotes", e.path, e.op)
+		}
+		if e.re, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+	case "<", "<=", ">", ">=":
+		if _, ok := e.lit.(float64); !ok {
+			return nil, fmt.Errorf("%s %s needs a number", e.path, e.op)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,76 @@
+package tailor
+
+import (
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestExpr(t *testing.T) {
+	payload, _ := structpb.NewStruct(map[string]any{
+		"methodName": "SetIamPolicy",
+		"principal":  "alice@example.com",
+		"code":       7,
+		"ok":         false,
+		"perms":      []any{map[string]any{"name": "a.get"}, map[string]any{"name": "a.set"}},
+	})
+	entry := &logpb.LogEntry{
+		LogName:  "projects/p/logs/app",
+		Severity: ltype.LogSeverity_ERROR,
+		Labels:   map[string]string{"k8s.io/app": "web"},
+		Payload:  &logpb.LogEntry_JsonPayload{JsonPayload: payload},
+	}
+	tr := newTestTailor(t, &Config{})
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`jsonPayload.methodName == "SetIamPolicy"`, true},
+		{`jsonPayload.methodName != 'SetIamPolicy'`, false},
+		{`jsonPayload.principal =~ "@example\.com$"`, true},
+		{`jsonPayload.principal !~ "@example\.com$"`, false},
+		{`jsonPayload.code > 5 and jsonPayload.code <= 7`, true},
+		{`jsonPayload.code == 7`, true},
+		{`severity == "ERROR" and severity >= 500`, true},
+		{`severity < 400`, false},
+		{`jsonPayload.ok`, false},
+		{`jsonPayload.ok == false`, true},
+		{`jsonPayload.nope`, false},
+		{`jsonPayload.nope == null`, true},
+		{`not jsonPayload.nope`, true},
+		{`jsonPayload.nope or jsonPayload.methodName`, true},
+		{`jsonPayload.nope or (jsonPayload.code > 1 and not jsonPayload.ok)`, true},
+		{`jsonPayload.perms[*].name == "a.set"`, true},
+		{`jsonPayload.perms[*].name == "a.delete"`, false},
+		{`labels.key(k8s.io/app) == "web"`, true},
+	}
+	for _, tt := range tests {
+		e, err := compileExpr(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := e.eval(tr, entry); got != tt.expected {
+			t.Errorf("%s = %v; want %v", tt.expr, got, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{
+		``,
+		`methodName == "x"`,
+		`jsonPayload.x ==`,
+		`jsonPayload.x = "y"`,
+		`jsonPayload.x > "y"`,
+		`jsonPayload.x =~ "("`,
+		`(jsonPayload.x`,
+		`jsonPayload.x "y"`,
+		`jsonPayload.x == "y`,
+	} {
+		if _, err := compileExpr(bad); err == nil {
+			t.Errorf("%q should have failed", bad)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package tailor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Adds where IPs in entries are from, using local MaxMind databases: a
+// City or Country one for the country and city and an ASN one for the
+// ASN and org. For an IP output named caller the fields are
+// caller-country, caller-city, caller-asn and caller-org.
+type GeoIP struct {
+	Files []string `yaml:"files"`
+	// Output names and the paths to IPs.
+	IPs []OutputMap `yaml:"ips"`
+	// Lookups remembered. DefaultGeoIPCacheSize if 0.
+	CacheSize int `yaml:"cache-size"`
+}
+
+const DefaultGeoIPCacheSize = 10000
+
+type geoLocator struct {
+	readers   []*maxminddb.Reader
+	ips       []OutputMap
+	cacheSize int
+
+	mu    sync.Mutex
+	cache map[string]*geoInfo // by IP
+}
+
+// What's looked up. Each database fills in the parts it has.
+type geoInfo struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// Reads the databases. They're read into memory rather than mapped, so a
+// Tailor that's thrown away doesn't leave them open. Gives back nil for a
+// nil GeoIP.
+func newGeoLocator(g *GeoIP) (*geoLocator, error) {
+	if g == nil {
+		return nil, nil
+	}
+	if len(g.Files) == 0 {
+		return nil, errors.New("geoip needs files")
+	}
+	for _, om := range g.IPs {
+		name := fieldName(om)
+		path, ok := om[name].(string)
+		if !ok {
+			return nil, fmt.Errorf("geoip %s: needs a path", name)
+		}
+		if err := CheckPath(path); err != nil {
+			return nil, fmt.Errorf("geoip %s: %v", name, err)
+		}
+	}
+	gl := &geoLocator{ips: g.IPs, cacheSize: g.CacheSize, cache: map[string]*geoInfo{}}
+	if gl.cacheSize <= 0 {
+		gl.cacheSize = DefaultGeoIPCacheSize
+	}
+	for _, file := range g.Files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %v", err)
+		}
+		r, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("geoip %s: %v", file, err)
+		}
+		gl.readers = append(gl.readers, r)
+	}
+	return gl, nil
+}
+
+// The names of the fields it adds.
+func (gl *geoLocator) columns() []string {
+	var columns []string
+	for _, om := range gl.ips {
+		name := fieldName(om)
+		columns = append(columns, name+"-country", name+"-city", name+"-asn", name+"-org")
+	}
+	return columns
+}
+
+// Adds the fields for each IP to the record. They're null for what the
+// databases don't know and for values that aren't IPs.
+func (gl *geoLocator) apply(t *Tailor, entry *logpb.LogEntry, memo parseMemo, rec *Record) {
+	for _, om := range gl.ips {
+		name := fieldName(om)
+		v, _ := t.value(entry, StringValue(om[name]), memo)
+		var info *geoInfo
+		if s, ok := v.(string); ok && s != "" {
+			info = gl.lookup(t, s)
+		}
+		var country, city, asn, org any
+		if info != nil {
+			if info.Country.ISOCode != "" {
+				country = info.Country.ISOCode
+			}
+			if n := info.City.Names["en"]; n != "" {
+				city = n
+			}
+			if info.ASN != 0 {
+				asn = int(info.ASN)
+			}
+			if info.Org != "" {
+				org = info.Org
+			}
+		}
+		rec.Set(name+"-country", country)
+		rec.Set(name+"-city", city)
+		rec.Set(name+"-asn", asn)
+		rec.Set(name+"-org", org)
+	}
+}
+
+// Looks the IP up in each database, or gives back what it found last
+// time. The cache is emptied when it's full. Nil if it isn't an IP.
+func (gl *geoLocator) lookup(t *Tailor, s string) *geoInfo {
+	gl.mu.Lock()
+	info, ok := gl.cache[s]
+	gl.mu.Unlock()
+	if ok {
+		return info
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		info = &geoInfo{}
+		for _, r := range gl.readers {
+			if err := r.Lookup(ip, info); err != nil {
+				t.report(fmt.Errorf("geoip %s: %v", s, err))
+			}
+		}
+	}
+
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if len(gl.cache) >= gl.cacheSize {
+		clear(gl.cache)
+	}
+	gl.cache[s] = info
+	return info
+}
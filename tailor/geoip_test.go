@@ -0,0 +1,113 @@
+package tailor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Writes a database with the data for each network.
+func writeMMDB(t *testing.T, file, dbType string, networks map[string]mmdbtype.Map) {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, RecordSize: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cidr, data := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	city, asn := filepath.Join(dir, "city.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, city, "GeoLite2-City", map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"city":    mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("London")}},
+		},
+		"2a02:d1c0::/32": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("SE")},
+		},
+	})
+	writeMMDB(t, asn, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"autonomous_system_number":       mmdbtype.Uint32(20712),
+			"autonomous_system_organization": mmdbtype.String("Andrews & Arnold Ltd"),
+		},
+	})
+
+	tr := newTestTailor(t, &Config{
+		Common: []OutputMap{{"id": "insertId"}},
+		GeoIP: &GeoIP{
+			Files:     []string{city, asn},
+			IPs:       []OutputMap{{"caller": "jsonPayload.callerIp"}},
+			CacheSize: 2,
+		},
+	})
+	record := func(ip string) *Record {
+		t.Helper()
+		payload, _ := structpb.NewStruct(map[string]any{"callerIp": ip})
+		rec, err := tr.Record(&logpb.LogEntry{InsertId: "1", Payload: &logpb.LogEntry_JsonPayload{JsonPayload: payload}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	tests := []struct {
+		ip       string
+		expected []any // country, city, asn, org
+	}{
+		{"81.2.69.160", []any{"GB", "London", 20712, "Andrews & Arnold Ltd"}},
+		{"2a02:d1c0::1", []any{"SE", nil, nil, nil}},
+		{"8.8.8.8", []any{nil, nil, nil, nil}},
+		{"gce-internal-ip", []any{nil, nil, nil, nil}},
+		{"81.2.69.160", []any{"GB", "London", 20712, "Andrews & Arnold Ltd"}}, // again after the cache filled up
+	}
+	for _, tt := range tests {
+		rec := record(tt.ip)
+		var got []any
+		for _, f := range rec.Fields[1:] {
+			got = append(got, f.Value)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: got %v; want %v", tt.ip, got, tt.expected)
+		}
+	}
+	if len(tr.geo.cache) > 2 {
+		t.Errorf("%d IPs cached; cache-size is 2", len(tr.geo.cache))
+	}
+	expected := []string{"id", "caller-country", "caller-city", "caller-asn", "caller-org"}
+	if columns := tr.Columns(); !reflect.DeepEqual(columns, expected) {
+		t.Errorf("columns = %v; want %v", columns, expected)
+	}
+
+	if err := CheckGeoIP(&GeoIP{Files: []string{filepath.Join(dir, "nope.mmdb")}}); err == nil {
+		t.Errorf("a database that's not there should fail")
+	}
+	if err := CheckGeoIP(&GeoIP{Files: []string{city}, IPs: []OutputMap{{"caller": "protopayload.callerIp"}}}); err == nil {
+		t.Errorf("a bad path should fail")
+	}
+}
@@ -0,0 +1,193 @@
+package tailor
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+// Keeps a fixed share of a log's entries. Which ones is decided by a hash
+// of the by: path, so entries from the same trace all stay or all go.
+type Sample struct {
+	Ratio float64 `yaml:"ratio"` // 0.1 keeps about one in ten
+	// Path to hash. trace by default, or insertId for entries without one.
+	By string `yaml:"by"`
+}
+
+// A token bucket for each value of the key: path. An empty key means one
+// bucket for the whole log.
+type RateLimit struct {
+	Rate    float64 `yaml:"rate"`  // entries per second
+	Burst   int     `yaml:"burst"` // rate (at least 1) if 0
+	Key     string  `yaml:"key"`
+	MaxKeys int     `yaml:"max-keys"`
+}
+
+const DefaultRateLimitMaxKeys = 10000
+
+// Why Allow turned an entry away.
+const (
+	SampledOut  = "sampled"
+	RateLimited = "rate-limited"
+)
+
+// Applies the logs' sample: and rate-limit: settings. Unlike a Tailor it
+// changes as it goes (the buckets fill and empty), so make one for each
+// stream of entries. It's safe for use by multiple goroutines.
+type Limiter struct {
+	t       *Tailor
+	mu      sync.Mutex
+	buckets map[int]map[string]*tokenBucket // by index in config.Logs
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(t *Tailor) *Limiter {
+	return &Limiter{t: t, buckets: map[int]map[string]*tokenBucket{}}
+}
+
+// A Limiter for t that starts with this one's buckets, for when the config
+// is reloaded. A log's buckets carry over if t's config has a log with the
+// same name and resource type and the same rate-limit key.
+func (l *Limiter) Reload(t *Tailor) *Limiter {
+	nl := NewLimiter(t)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, buckets := range l.buckets {
+		old := &l.t.config.Logs[i]
+		for j := range t.config.Logs {
+			log := &t.config.Logs[j]
+			if log.Name != old.Name || log.ResType != old.ResType {
+				continue
+			}
+			if log.RateLimit != nil && log.RateLimit.Key == old.RateLimit.Key && nl.buckets[j] == nil {
+				nl.buckets[j] = make(map[string]*tokenBucket, len(buckets))
+				for k, b := range buckets {
+					nb := *b
+					nl.buckets[j][k] = &nb
+				}
+			}
+			break
+		}
+	}
+	return nl
+}
+
+// False if the entry's log samples it out or is over its rate limit. The
+// reason is SampledOut or RateLimited. The first matching log with either
+// setting is the one used.
+func (l *Limiter) Allow(entry *logpb.LogEntry) (bool, string) {
+	return l.allowAt(entry, time.Now())
+}
+
+func (l *Limiter) allowAt(entry *logpb.LogEntry, now time.Time) (bool, string) {
+	lname := LogName(entry)
+	for i := range l.t.config.Logs {
+		log := &l.t.config.Logs[i]
+		if log.Name != lname || (log.ResType != "" && log.ResType != entry.Resource.GetType()) {
+			continue
+		}
+		if log.Sample == nil && log.RateLimit == nil {
+			continue
+		}
+		if log.Sample != nil && !l.sampled(log.Sample, entry) {
+			return false, SampledOut
+		}
+		if log.RateLimit != nil && !l.take(i, log.RateLimit, entry, now) {
+			return false, RateLimited
+		}
+		return true, ""
+	}
+	return true, ""
+}
+
+// True if the entry is in the sample.
+func (l *Limiter) sampled(s *Sample, entry *logpb.LogEntry) bool {
+	if s.Ratio >= 1 {
+		return true
+	}
+	var key string
+	if s.By == "" {
+		key = entry.Trace
+		if key == "" {
+			key = entry.InsertId
+		}
+	} else {
+		v, _ := l.t.Value(entry, s.By)
+		key = StringValue(v)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(mix(h.Sum64())) < s.Ratio*math.MaxUint64
+}
+
+// FNV's high bits don't change much for keys that are alike (like
+// insertIds), so they get stirred up.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Takes a token from the entry's bucket if there's one to take.
+func (l *Limiter) take(i int, rl *RateLimit, entry *logpb.LogEntry, now time.Time) bool {
+	key := ""
+	if rl.Key != "" {
+		v, _ := l.t.Value(entry, rl.Key)
+		key = StringValue(v)
+	}
+	burst := float64(rl.Burst)
+	if burst <= 0 {
+		burst = max(rl.Rate, 1)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buckets := l.buckets[i]
+	if buckets == nil {
+		buckets = map[string]*tokenBucket{}
+		l.buckets[i] = buckets
+	}
+	b := buckets[key]
+	if b == nil {
+		maxKeys := rl.MaxKeys
+		if maxKeys <= 0 {
+			maxKeys = DefaultRateLimitMaxKeys
+		}
+		if len(buckets) >= maxKeys {
+			pruneBuckets(buckets, maxKeys, rl.Rate, burst, now)
+		}
+		b = &tokenBucket{tokens: burst, last: now}
+		buckets[key] = b
+	}
+
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Makes room for new keys. Full buckets are the same as new ones, so they
+// go first. If that's not enough, they all go.
+func pruneBuckets(buckets map[string]*tokenBucket, maxKeys int, rate, burst float64, now time.Time) {
+	for k, b := range buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(buckets, k)
+		}
+	}
+	if len(buckets) >= maxKeys {
+		clear(buckets)
+	}
+}
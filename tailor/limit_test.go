@@ -0,0 +1,110 @@
+package tailor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+func TestSample(t *testing.T) {
+	tr := newTestTailor(t, &Config{Logs: []Log{
+		{Name: "noisy", Sample: &Sample{Ratio: 0.25}},
+		{Name: "by-label", Sample: &Sample{Ratio: 0.5, By: "labels.user"}},
+	}})
+	l := NewLimiter(tr)
+
+	kept := 0
+	for i := 0; i < 4000; i++ {
+		entry := &logpb.LogEntry{LogName: "projects/p/logs/noisy", InsertId: fmt.Sprint(i)}
+		if ok, reason := l.Allow(entry); ok {
+			kept++
+		} else if reason != SampledOut {
+			t.Fatalf("reason = %q", reason)
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("kept %d of 4000 at 0.25", kept)
+	}
+
+	// Everything in a trace stays or goes together, every time.
+	for i := 0; i < 20; i++ {
+		trace := fmt.Sprintf("projects/p/traces/%d", i)
+		first, _ := l.Allow(&logpb.LogEntry{LogName: "projects/p/logs/noisy", InsertId: "a", Trace: trace})
+		for _, id := range []string{"b", "c", "d"} {
+			if ok, _ := l.Allow(&logpb.LogEntry{LogName: "projects/p/logs/noisy", InsertId: id, Trace: trace}); ok != first {
+				t.Fatalf("trace %s was split", trace)
+			}
+		}
+	}
+
+	first, _ := l.Allow(&logpb.LogEntry{LogName: "projects/p/logs/by-label", InsertId: "1", Labels: map[string]string{"user": "u"}})
+	if ok, _ := l.Allow(&logpb.LogEntry{LogName: "projects/p/logs/by-label", InsertId: "2", Labels: map[string]string{"user": "u"}}); ok != first {
+		t.Errorf("the same user should be sampled the same way")
+	}
+	if ok, _ := l.Allow(&logpb.LogEntry{LogName: "projects/p/logs/other"}); !ok {
+		t.Errorf("logs without sample: shouldn't be sampled")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tr := newTestTailor(t, &Config{Logs: []Log{
+		{Name: "app", RateLimit: &RateLimit{Rate: 1, Burst: 2, Key: "labels.user", MaxKeys: 2}},
+	}})
+	l := NewLimiter(tr)
+	entry := func(user string) *logpb.LogEntry {
+		return &logpb.LogEntry{LogName: "projects/p/logs/app", Labels: map[string]string{"user": user}}
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	expect := func(user string, expected bool) {
+		t.Helper()
+		ok, reason := l.allowAt(entry(user), now)
+		if ok != expected || (!ok && reason != RateLimited) {
+			t.Errorf("%s at %s: got %v, %q; want %v", user, now.Format(time.TimeOnly), ok, reason, expected)
+		}
+	}
+	expect("a", true)
+	expect("a", true)
+	expect("a", false) // the burst is used up
+	expect("b", true)  // b has its own bucket
+
+	now = now.Add(time.Second)
+	expect("a", true)
+	expect("a", false)
+
+	// A third key makes room by dropping the buckets that are full.
+	now = now.Add(10 * time.Second)
+	expect("c", true)
+	expect("c", true)
+	expect("c", false)
+	if n := len(l.buckets[0]); n > 2 {
+		t.Errorf("%d buckets; max-keys is 2", n)
+	}
+}
+
+func TestLimiterReload(t *testing.T) {
+	logs := func(key string) *Config {
+		return &Config{Logs: []Log{
+			{Name: "other"},
+			{Name: "app", RateLimit: &RateLimit{Rate: 1, Burst: 1, Key: key}},
+		}}
+	}
+	entry := &logpb.LogEntry{LogName: "projects/p/logs/app", Labels: map[string]string{"user": "a"}}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := NewLimiter(newTestTailor(t, logs("labels.user")))
+	l.allowAt(entry, now)
+
+	// The same log, moved, with the same key keeps its bucket.
+	moved := &Config{Logs: []Log{logs("labels.user").Logs[1]}}
+	if ok, _ := l.Reload(newTestTailor(t, moved)).allowAt(entry, now); ok {
+		t.Errorf("the bucket didn't carry over")
+	}
+	if ok, _ := l.Reload(newTestTailor(t, logs("labels.team"))).allowAt(entry, now); !ok {
+		t.Errorf("buckets for a different key shouldn't carry over")
+	}
+	if ok, _ := l.allowAt(entry, now); ok {
+		t.Errorf("a reload changed the old limiter")
+	}
+}
@@ -0,0 +1,257 @@
+package tailor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Declares a parser for string values at a path. After that, name can be
+// used as the next path element: textPayload.json.user.id
+type ParserDef struct {
+	Path  string `yaml:"path"`
+	Type  string `yaml:"type"` // json, logfmt, regex or stacktrace
+	Name  string `yaml:"name"` // defaults to the type
+	Regex string `yaml:"regex"`
+}
+
+type textParser struct {
+	path  string
+	parse func(s string) (any, error)
+}
+
+func newTextParsers(defs []ParserDef) (map[string][]*textParser, error) {
+	parsers := make(map[string][]*textParser)
+	for _, def := range defs {
+		p, err := newTextParser(def)
+		if err != nil {
+			return nil, err
+		}
+		name := def.Name
+		if name == "" {
+			name = def.Type
+		}
+		parsers[name] = append(parsers[name], p)
+	}
+	return parsers, nil
+}
+
+func newTextParser(def ParserDef) (*textParser, error) {
+	if def.Path == "" {
+		return nil, fmt.Errorf("parser %s needs a path", def.Type)
+	}
+	elems, err := parsePath(def.Path)
+	if err != nil {
+		return nil, err
+	}
+	if hasFanOut(elems) {
+		return nil, fmt.Errorf("parser path can't have wildcards: %s", def.Path)
+	}
+	p := &textParser{path: trailOf(elems)}
+
+	switch def.Type {
+	case "json":
+		p.parse = parseJSONText
+	case "logfmt":
+		p.parse = parseLogfmt
+	case "stacktrace":
+		p.parse = parseStackTrace
+	case "regex":
+		re, err := regexp.Compile(def.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("parser regex for %s: %v", def.Path, err)
+		}
+		if len(re.SubexpNames()) < 2 {
+			return nil, fmt.Errorf("parser regex for %s needs named groups", def.Path)
+		}
+		p.parse = func(s string) (any, error) {
+			return parseRegexText(re, s)
+		}
+	default:
+		return nil, fmt.Errorf("unknown parser type %q (json, logfmt, regex or stacktrace)", def.Type)
+	}
+	return p, nil
+}
+
+// The path we walked down to get somewhere, keys only. Lists don't count.
+func trailOf(elems []pathElem) string {
+	var keys []string
+	for _, e := range elems {
+		if e.kind == keyElem {
+			keys = append(keys, e.key)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+func appendTrail(trail, key string) string {
+	if trail == "" {
+		return key
+	}
+	return trail + "." + key
+}
+
+// Finds the parser called name for the string at trail.
+func (t *Tailor) findParser(trail, name string) *textParser {
+	for _, p := range t.parsers[name] {
+		if p.path == trail {
+			return p
+		}
+	}
+	return nil
+}
+
+func parseJSONText(s string) (any, error) {
+	var result any
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseRegexText(re *regexp.Regexp, s string) (any, error) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("no match")
+	}
+	result := map[string]any{}
+	for i, name := range re.SubexpNames() {
+		if i > 0 && name != "" {
+			result[name] = m[i]
+		}
+	}
+	return result, nil
+}
+
+// key=value pairs. Values can be "quoted with \"escapes\"". A bare key is true.
+func parseLogfmt(s string) (any, error) {
+	result := map[string]any{}
+	i := 0
+	for i < len(s) {
+		for i < len(s) && unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		key := s[start:i]
+		if key == "" {
+			i++
+			continue
+		}
+		if i >= len(s) || s[i] != '=' {
+			result[key] = true
+			continue
+		}
+		i++ // the =
+
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated quote for %s", key)
+			}
+			v, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted value for %s: %v", key, err)
+			}
+			result[key] = v
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(s) && !unicode.IsSpace(rune(s[i])) {
+			i++
+		}
+		result[key] = s[start:i]
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no key=value pairs")
+	}
+	return result, nil
+}
+
+var (
+	javaFrameRE = regexp.MustCompile(`^\s+at ([^(]+)\(([^:)]+)(?::(\d+))?\)`)
+	javaCauseRE = regexp.MustCompile(`^Caused by: (.*)$`)
+	goFuncRE    = regexp.MustCompile(`^([\w./*()-]+)\(.*\)$`)
+	goFileRE    = regexp.MustCompile(`^\s+(\S+\.go):(\d+)`)
+)
+
+// Understands Java exceptions and Go panics. Gives back the message, the
+// frames (function, file, line) and for Java the caused-by chain.
+func parseStackTrace(s string) (any, error) {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if len(lines) < 2 {
+		return nil, errors.New("not a stack trace")
+	}
+	for _, l := range lines {
+		if javaFrameRE.MatchString(l) {
+			return parseJavaTrace(lines), nil
+		}
+		if strings.HasPrefix(l, "goroutine ") {
+			return parseGoTrace(lines), nil
+		}
+	}
+	return nil, errors.New("not a stack trace")
+}
+
+func parseJavaTrace(lines []string) map[string]any {
+	frames := []any{}
+	causes := []any{}
+	for _, l := range lines[1:] {
+		if m := javaFrameRE.FindStringSubmatch(l); m != nil {
+			frame := map[string]any{"function": m[1], "file": m[2]}
+			if n, err := strconv.Atoi(m[3]); err == nil {
+				frame["line"] = n
+			}
+			frames = append(frames, frame)
+		} else if m := javaCauseRE.FindStringSubmatch(l); m != nil {
+			causes = append(causes, m[1])
+		}
+	}
+	return map[string]any{
+		"language": "java",
+		"message":  strings.TrimSpace(lines[0]),
+		"frames":   frames,
+		"causes":   causes,
+	}
+}
+
+func parseGoTrace(lines []string) map[string]any {
+	frames := []any{}
+	message := ""
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		if message == "" && strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "goroutine ") {
+			message = strings.TrimSpace(l)
+			continue
+		}
+		m := goFuncRE.FindStringSubmatch(l)
+		if m == nil || i+1 >= len(lines) {
+			continue
+		}
+		f := goFileRE.FindStringSubmatch(lines[i+1])
+		if f == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(f[2])
+		frames = append(frames, map[string]any{"function": m[1], "file": f[1], "line": line})
+		i++
+	}
+	return map[string]any{
+		"language": "go",
+		"message":  message,
+		"frames":   frames,
+	}
+}
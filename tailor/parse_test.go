@@ -0,0 +1,143 @@
+package tailor
+
+import (
+	"reflect"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	got, err := parseLogfmt(`level=info msg="hello \"world\"" latency=12ms debug`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"level": "info", "msg": `hello "world"`, "latency": "12ms", "debug": true}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseLogfmt() = %v; want %v", got, expected)
+	}
+
+	if _, err := parseLogfmt(`msg="oops`); err == nil {
+		t.Errorf("unterminated quote should fail")
+	}
+}
+
+func TestParseStackTrace(t *testing.T) {
+	java := "java.lang.IllegalStateException: boom\n" +
+		"\tat com.example.Foo.bar(Foo.java:42)\n" +
+		"\tat com.example.Main.main(Main.java:7)\n" +
+		"Caused by: java.io.IOException: disk\n" +
+		"\tat com.example.Disk.read(Disk.java)\n"
+	got, err := parseStackTrace(java)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := got.(map[string]any)
+	if m["language"] != "java" || m["message"] != "java.lang.IllegalStateException: boom" {
+		t.Errorf("java trace = %v", m)
+	}
+	frames := m["frames"].([]any)
+	if len(frames) != 3 || !reflect.DeepEqual(frames[0], map[string]any{"function": "com.example.Foo.bar", "file": "Foo.java", "line": 42}) {
+		t.Errorf("java frames = %v", frames)
+	}
+	if !reflect.DeepEqual(m["causes"], []any{"java.io.IOException: disk"}) {
+		t.Errorf("java causes = %v", m["causes"])
+	}
+
+	golang := "panic: runtime error: index out of range\n\n" +
+		"goroutine 1 [running]:\n" +
+		"main.handler(0x0?)\n" +
+		"\t/app/main.go:25 +0x1d\n" +
+		"main.main()\n" +
+		"\t/app/main.go:10 +0x25\n"
+	got, err = parseStackTrace(golang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = got.(map[string]any)
+	expected := []any{
+		map[string]any{"function": "main.handler", "file": "/app/main.go", "line": 25},
+		map[string]any{"function": "main.main", "file": "/app/main.go", "line": 10},
+	}
+	if m["message"] != "panic: runtime error: index out of range" || !reflect.DeepEqual(m["frames"], expected) {
+		t.Errorf("go trace = %v", m)
+	}
+
+	if _, err := parseStackTrace("just a line"); err == nil {
+		t.Errorf("plain text should not parse as a stack trace")
+	}
+}
+
+func TestTextPayloadParsers(t *testing.T) {
+	tr := newTestTailor(t, &Config{Parsers: []ParserDef{
+		{Path: "textPayload", Type: "json"},
+		{Path: "textPayload", Type: "logfmt", Name: "kv"},
+		{Path: "textPayload", Type: "regex", Name: "access", Regex: `^(?P<method>\S+) (?P<path>\S+)`},
+	}})
+
+	entry := &logpb.LogEntry{Payload: &logpb.LogEntry_TextPayload{TextPayload: `{"user": {"id": 7}, "tags": ["a", "b"]}`}}
+	tests := []struct {
+		path     string
+		expected any
+	}{
+		{path: "textPayload.json.user.id", expected: 7.0},
+		{path: "textPayload.json.tags[1]", expected: "b"},
+		{path: "textPayload.json.tags[*]", expected: []any{"a", "b"}},
+	}
+	for _, tt := range tests {
+		got, err := tr.Value(entry, tt.path)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Value(%q) = %v, %v; want %v", tt.path, got, err, tt.expected)
+		}
+	}
+	if _, err := tr.Value(entry, "textPayload.kv.user"); err == nil {
+		t.Errorf("JSON shouldn't parse as logfmt")
+	}
+
+	entry = &logpb.LogEntry{Payload: &logpb.LogEntry_TextPayload{TextPayload: "GET /healthz 200"}}
+	if got, err := tr.Value(entry, "textPayload.access.path"); err != nil || got != "/healthz" {
+		t.Errorf("textPayload.access.path = %v, %v", got, err)
+	}
+}
+
+func TestRecordParsesOnce(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		Parsers: []ParserDef{{Path: "textPayload", Type: "json"}},
+		Common: []OutputMap{
+			{"user": "textPayload.json.user.id"},
+			{"tags": "textPayload.json.tags[*]"},
+			{"first-tag": OutputMap{"src": "textPayload.json.tags[0]", "default": "none"}},
+			{"body": "textPayload.json"},
+			{"body2": "textPayload.json"},
+		},
+	})
+	p := tr.parsers["json"][0]
+	parse, calls := p.parse, 0
+	p.parse = func(s string) (any, error) {
+		calls++
+		return parse(s)
+	}
+
+	entry := &logpb.LogEntry{Payload: &logpb.LogEntry_TextPayload{TextPayload: `{"user": {"id": 7}, "tags": ["a", "b"]}`}}
+	rec, err := tr.Record(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("the payload was parsed %d times for one record", calls)
+	}
+	body, _ := rec.Get("body")
+	body2, _ := rec.Get("body2")
+	body.(map[string]any)["user"] = "changed"
+	if reflect.DeepEqual(body, body2) {
+		t.Errorf("fields share what was parsed")
+	}
+	if v, _ := rec.Get("first-tag"); v != "a" {
+		t.Errorf("first-tag = %v", v)
+	}
+
+	tr.Record(entry)
+	if calls != 2 {
+		t.Errorf("parsed %d times for two records; the memo is per record", calls)
+	}
+}
@@ -0,0 +1,466 @@
+package tailor
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Entry paths look like this:
+//
+//	resource.labels.project_id           fields and map keys
+//	labels.key(authorization.k8s.io/x)   a key with dots in it
+//	protoPayload.authorizationInfo[0]    list index (negative counts from the end)
+//	protoPayload.authorizationInfo[*]    every element
+//	resource.labels.*                    every value in a map or struct
+//	protoPayload..permission             permission at any depth
+//
+// A path with [*], * or .. in it gives back a list of everything it found.
+// Strings with a parser declared for them can be walked into using the
+// parser's name: textPayload.json.user.id
+
+type pathElemKind int
+
+const (
+	keyElem      pathElemKind = iota // a field or map key
+	indexElem                        // [n]
+	allElem                          // [*]
+	wildcardElem                     // *
+	descentElem                      // .. (or **)
+)
+
+type pathElem struct {
+	kind  pathElemKind
+	key   string
+	index int
+}
+
+func (e pathElem) String() string {
+	switch e.kind {
+	case indexElem:
+		return "[" + strconv.Itoa(e.index) + "]"
+	case allElem:
+		return "[*]"
+	case wildcardElem:
+		return "*"
+	case descentElem:
+		return ".."
+	}
+	return e.key
+}
+
+// Gets the path elements from a string, ignoring syntax errors.
+// resource.labels.dataset_id
+// labels.key(authorization.k8s.io/decision)
+func pathElements(path string) []string {
+	elems, _ := SplitPath(path)
+	return elems
+}
+
+// The elements of a path as strings. key(a.b) comes back as a.b.
+func SplitPath(path string) ([]string, error) {
+	elems, err := parsePath(path)
+	var result []string
+	for _, e := range elems {
+		result = append(result, e.String())
+	}
+	return result, err
+}
+
+func parsePath(path string) ([]pathElem, error) {
+	const prefix = "key("
+	var result []pathElem
+
+	for i := 0; i < len(path); {
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			result = append(result, pathElem{kind: descentElem})
+			i += 2
+
+		case path[i] == '.':
+			i++
+
+		case strings.HasPrefix(path[i:], prefix):
+			// The key runs to the first ) that ends an element.
+			start := i + len(prefix)
+			end := -1
+			for j := start; j < len(path); j++ {
+				if path[j] == ')' && (j+1 == len(path) || path[j+1] == '.' || path[j+1] == '[') {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				return result, errors.New("Parse error on key(): " + path)
+			}
+			result = append(result, pathElem{kind: keyElem, key: path[start:end]})
+			i = end + 1
+
+		case path[i] == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return result, errors.New("Parse error on []: " + path)
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			if inner == "*" {
+				result = append(result, pathElem{kind: allElem})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return result, fmt.Errorf("Bad index [%s]: %s", inner, path)
+				}
+				result = append(result, pathElem{kind: indexElem, index: n})
+			}
+			i += end + 1
+
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			name := path[i : i+end]
+			switch name {
+			case "*":
+				result = append(result, pathElem{kind: wildcardElem})
+			case "**":
+				result = append(result, pathElem{kind: descentElem})
+			default:
+				result = append(result, pathElem{kind: keyElem, key: name})
+			}
+			i += end
+		}
+	}
+	return result, nil
+}
+
+func hasFanOut(elems []pathElem) bool {
+	for _, e := range elems {
+		if e.kind == allElem || e.kind == wildcardElem || e.kind == descentElem {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets data from the LogEntry with a path as a specifier. An error means
+// there's nothing there. It's never put in the output as a value.
+// example path: resources.labels.project_id
+func (t *Tailor) Value(entry *logpb.LogEntry, path string) (any, error) {
+	return t.value(entry, path, nil)
+}
+
+// Strings parsed while building one record, so outputs that walk into the
+// same string (textPayload.json.a, textPayload.json.b) only parse it once.
+// The protoPayload is kept here too once it's decoded.
+type parseMemo map[parseKey]parsedString
+
+type parseKey struct {
+	parser  *textParser
+	s       string
+	payload *anypb.Any
+}
+
+type parsedString struct {
+	val reflect.Value
+	err error
+}
+
+// Value with a memo for parsed strings, which can be nil. With a memo, maps
+// and lists come back copied so fields don't share what was parsed.
+func (t *Tailor) value(entry *logpb.LogEntry, path string, memo parseMemo) (any, error) {
+	elems, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root any = entry
+	trail := ""
+	// Compensate for cloud logging's GUIs transforming payload to different names
+	if len(elems) > 0 && elems[0].kind == keyElem {
+		switch elems[0].key {
+		case "protoPayload", "jsonPayload", "textPayload":
+			root = entry.Payload
+			trail = elems[0].key
+			elems = elems[1:]
+		}
+	}
+
+	if hasFanOut(elems) {
+		result := []any{}
+		t.collectPath(reflect.ValueOf(root), elems, trail, memo, &result)
+		if memo != nil {
+			return copyParsed(result), nil
+		}
+		return result, nil
+	}
+
+	v, err := t.walkPath(reflect.ValueOf(root), elems, path, trail, memo)
+	if err != nil {
+		return nil, err
+	}
+	if memo != nil {
+		v = copyParsed(v)
+	}
+
+	// Hack for logname:
+	if path == "logName" {
+		if s, ok := v.(string); ok {
+			p, _ := url.PathUnescape(s)
+			return p, nil
+		}
+	}
+	return v, nil
+}
+
+// Follows a path with no fan-out to a single value. The trail is the keys
+// walked so far, for finding parsers.
+func (t *Tailor) walkPath(val reflect.Value, elems []pathElem, path string, trail string, memo parseMemo) (any, error) {
+	for _, e := range elems {
+		val = t.derefValue(val, memo)
+		if !val.IsValid() {
+			return nil, fmt.Errorf("Field %s not found", path)
+		}
+
+		switch e.kind {
+		case keyElem:
+			if parsed, ok, err := t.parseString(val, trail, e.key, memo); ok {
+				if err != nil {
+					return nil, fmt.Errorf("Can't parse %s as %s: %v", trail, e.key, err)
+				}
+				val = parsed
+				trail = appendTrail(trail, e.key)
+				continue
+			}
+			trail = appendTrail(trail, e.key)
+			child, ok := childByKey(val, e.key)
+			if !ok {
+				if val.Kind() == reflect.Map {
+					return nil, fmt.Errorf("Key %s not found in map", e.key)
+				}
+				return nil, fmt.Errorf("Field %s not found", path)
+			}
+			val = child
+		case indexElem:
+			child, ok := childByIndex(val, e.index)
+			if !ok {
+				return nil, fmt.Errorf("Index %d not found in %s", e.index, path)
+			}
+			val = child
+		}
+	}
+
+	val = t.resolveValue(val, memo)
+	if !val.IsValid() {
+		return nil, nil
+	}
+	return val.Interface(), nil
+}
+
+// Follows a path that can branch and appends everything found. Branches that
+// don't pan out are skipped.
+func (t *Tailor) collectPath(val reflect.Value, elems []pathElem, trail string, memo parseMemo, result *[]any) {
+	if len(elems) == 0 {
+		if v := t.resolveValue(val, memo); v.IsValid() {
+			*result = append(*result, v.Interface())
+		}
+		return
+	}
+
+	val = t.derefValue(val, memo)
+	if !val.IsValid() {
+		return
+	}
+	e, rest := elems[0], elems[1:]
+
+	switch e.kind {
+	case keyElem:
+		if parsed, ok, err := t.parseString(val, trail, e.key, memo); ok {
+			if err == nil {
+				t.collectPath(parsed, rest, appendTrail(trail, e.key), memo, result)
+			}
+		} else if child, ok := childByKey(val, e.key); ok {
+			t.collectPath(child, rest, appendTrail(trail, e.key), memo, result)
+		}
+	case indexElem:
+		if child, ok := childByIndex(val, e.index); ok {
+			t.collectPath(child, rest, trail, memo, result)
+		}
+	case allElem:
+		if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+			for i := 0; i < val.Len(); i++ {
+				t.collectPath(val.Index(i), rest, trail, memo, result)
+			}
+		}
+	case wildcardElem:
+		for _, child := range children(val, trail) {
+			t.collectPath(child.val, rest, child.trail, memo, result)
+		}
+	case descentElem:
+		// Match right here, then keep looking further down.
+		t.collectPath(val, rest, trail, memo, result)
+		for _, child := range children(val, trail) {
+			t.collectPath(child.val, elems, child.trail, memo, result)
+		}
+	}
+}
+
+// If val is a string and there's a parser called name for it, parses it,
+// or gets what it was parsed to from the memo. ok is false if there's no
+// such parser.
+func (t *Tailor) parseString(val reflect.Value, trail, name string, memo parseMemo) (parsed reflect.Value, ok bool, err error) {
+	if val.Kind() != reflect.String {
+		return reflect.Value{}, false, nil
+	}
+	p := t.findParser(trail, name)
+	if p == nil {
+		return reflect.Value{}, false, nil
+	}
+	key := parseKey{parser: p, s: val.String()}
+	if ps, ok := memo[key]; ok {
+		return ps.val, true, ps.err
+	}
+	var ps parsedString
+	if v, err := p.parse(key.s); err != nil {
+		ps.err = err
+	} else {
+		ps.val = reflect.ValueOf(v)
+	}
+	if memo != nil {
+		memo[key] = ps
+	}
+	return ps.val, true, ps.err
+}
+
+// A copy of the maps and lists in v, all the way down.
+func copyParsed(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for k, x := range v {
+			result[k] = copyParsed(x)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, x := range v {
+			result[i] = copyParsed(x)
+		}
+		return result
+	}
+	return v
+}
+
+func childByKey(val reflect.Value, key string) (reflect.Value, bool) {
+	switch val.Kind() {
+	case reflect.Struct:
+		if key == "" {
+			return reflect.Value{}, false
+		}
+		f := val.FieldByName(capitalize(key))
+		return f, f.IsValid()
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		v := val.MapIndex(reflect.ValueOf(key).Convert(val.Type().Key()))
+		return v, v.IsValid()
+	}
+	return reflect.Value{}, false
+}
+
+func childByIndex(val reflect.Value, i int) (reflect.Value, bool) {
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return reflect.Value{}, false
+	}
+	if i < 0 {
+		i += val.Len()
+	}
+	if i < 0 || i >= val.Len() {
+		return reflect.Value{}, false
+	}
+	return val.Index(i), true
+}
+
+type childValue struct {
+	val   reflect.Value
+	trail string
+}
+
+// Exported struct fields, map values (sorted by key) or list elements.
+func children(val reflect.Value, trail string) []childValue {
+	var result []childValue
+	switch val.Kind() {
+	case reflect.Struct:
+		t := val.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.IsExported() {
+				name := strings.ToLower(f.Name[:1]) + f.Name[1:]
+				result = append(result, childValue{val.Field(i), appendTrail(trail, name)})
+			}
+		}
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			result = append(result, childValue{val.MapIndex(k), appendTrail(trail, fmt.Sprint(k.Interface()))})
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			result = append(result, childValue{val.Index(i), trail})
+		}
+	}
+	return result
+}
+
+// Turns payloads and timestamps into something useful and unwraps
+// interfaces. Pointers to structs are left alone so proto messages stay
+// proto messages.
+func (t *Tailor) resolveValue(val reflect.Value, memo parseMemo) reflect.Value {
+	for val.IsValid() && val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Value{}
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() || (val.Kind() == reflect.Ptr && val.IsNil()) {
+		return reflect.Value{}
+	}
+
+	switch p := val.Interface().(type) {
+	case *logpb.LogEntry_ProtoPayload:
+		if payload := t.protoPayload(*p, memo); payload != nil {
+			return reflect.ValueOf(payload)
+		}
+		return reflect.Value{}
+	case *logpb.LogEntry_JsonPayload:
+		return reflect.ValueOf(p.JsonPayload.AsMap())
+	case *logpb.LogEntry_TextPayload:
+		return reflect.ValueOf(p.TextPayload)
+	case *timestamppb.Timestamp:
+		return reflect.ValueOf(p.AsTime().Format(time.RFC3339Nano))
+	}
+	return val
+}
+
+// Like resolveValue but also follows pointers, for walking into things.
+func (t *Tailor) derefValue(val reflect.Value, memo parseMemo) reflect.Value {
+	for {
+		val = t.resolveValue(val, memo)
+		if !val.IsValid() || val.Kind() != reflect.Ptr {
+			return val
+		}
+		val = val.Elem()
+	}
+}
@@ -0,0 +1,126 @@
+package tailor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "a.b[0].c", expected: []string{"a", "b", "[0]", "c"}},
+		{input: "a[-1]", expected: []string{"a", "[-1]"}},
+		{input: "a[*].b", expected: []string{"a", "[*]", "b"}},
+		{input: "a.*.b", expected: []string{"a", "*", "b"}},
+		{input: "a..b", expected: []string{"a", "..", "b"}},
+		{input: "a.**.b", expected: []string{"a", "..", "b"}},
+		{input: "labels.key(k8s.io/x[0])[1]", expected: []string{"labels", "k8s.io/x[0]", "[1]"}},
+	}
+	for _, tt := range tests {
+		got, err := SplitPath(tt.input)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("SplitPath(%q) = %v, %v; want %v", tt.input, got, err, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{"a.key(b.c", "a[0", "a[x]"} {
+		if _, err := parsePath(bad); err == nil {
+			t.Errorf("parsePath(%q) should have failed", bad)
+		}
+	}
+}
+
+func TestValuePaths(t *testing.T) {
+	tr := newTestTailor(t, &Config{})
+	payload, err := structpb.NewStruct(map[string]any{
+		"authorizationInfo": []any{
+			map[string]any{"permission": "p1", "resource": "r1"},
+			map[string]any{"permission": "p2", "resource": "r2"},
+		},
+		"request": map[string]any{
+			"policy": map[string]any{"permission": "p3"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := &logpb.LogEntry{
+		Payload:   &logpb.LogEntry_JsonPayload{JsonPayload: payload},
+		Timestamp: timestamppb.New(ts),
+		Resource: &mrpb.MonitoredResource{
+			Type:   "gce_instance",
+			Labels: map[string]string{"project_id": "proj", "zone": "us-east1-b"},
+		},
+	}
+
+	tests := []struct {
+		path     string
+		expected any
+	}{
+		{path: "timestamp", expected: "2024-01-02T03:04:05Z"},
+		{path: "resource.labels.project_id", expected: "proj"},
+		{path: "jsonPayload.authorizationInfo[0].permission", expected: "p1"},
+		{path: "jsonPayload.authorizationInfo[-1].permission", expected: "p2"},
+		{path: "jsonPayload.authorizationInfo[*].resource", expected: []any{"r1", "r2"}},
+		{path: "resource.labels.*", expected: []any{"proj", "us-east1-b"}},
+		{path: "jsonPayload..permission", expected: []any{"p1", "p2", "p3"}},
+		{path: "jsonPayload.nope[*]", expected: []any{}},
+	}
+	for _, tt := range tests {
+		got, err := tr.Value(entry, tt.path)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Value(%q) = %#v, %v; want %#v", tt.path, got, err, tt.expected)
+		}
+	}
+
+	// Missing things are errors, never values.
+	for _, path := range []string{"jsonPayload.authorizationInfo[5]", "resource.labels.nope", "operation.id", "nope"} {
+		if got, err := tr.Value(entry, path); err == nil {
+			t.Errorf("Value(%q) = %#v; want an error", path, got)
+		}
+	}
+}
+
+func TestMissingPolicy(t *testing.T) {
+
+	entry := &logpb.LogEntry{InsertId: "abc"}
+	outputs := []OutputMap{
+		{"id": "insertId"},
+		{"nope": "labels.nope"},
+		{"fallback": OutputMap{"src": "labels.other", "default": "n/a"}},
+		{"kept": OutputMap{"src": "labels.other", "missing": "omit"}},
+	}
+
+	tests := []struct {
+		policy   string
+		expected OutputMap
+		fails    bool
+	}{
+		{policy: "", expected: OutputMap{"id": "abc", "nope": nil, "fallback": "n/a"}},
+		{policy: "omit", expected: OutputMap{"id": "abc", "fallback": "n/a"}},
+		{policy: "default", expected: OutputMap{"id": "abc", "nope": "-", "fallback": "n/a"}},
+		{policy: "fail", fails: true},
+	}
+	for _, tt := range tests {
+		tr := newTestTailor(t, &Config{Common: outputs, Missing: tt.policy, MissingDefault: "-"})
+		rec, err := tr.Record(entry)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("policy %q: expected an error", tt.policy)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(rec.Map(), map[string]any(tt.expected)) {
+			t.Errorf("policy %q: got %v, %v; want %v", tt.policy, rec, err, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package tailor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// Other payload types that show up in Cloud Logging. The audit and iam
+	// ones are imported in tailor.go.
+	_ "google.golang.org/genproto/googleapis/appengine/logging/v1"
+	_ "google.golang.org/genproto/googleapis/cloud/bigquery/logging/v1"
+	_ "google.golang.org/genproto/googleapis/cloud/osconfig/logging"
+	_ "google.golang.org/genproto/googleapis/cloud/secretmanager/logging/v1"
+	_ "google.golang.org/genproto/googleapis/iam/admin/v1"
+)
+
+// Extra protobuf types for decoding protoPayloads. Files are either
+// FileDescriptorSets (protoc --include_imports -o types.pb ...) or .proto
+// sources, which are compiled at startup.
+type Protos struct {
+	Files       []string `yaml:"files"`
+	ImportPaths []string `yaml:"import-paths"` // for .proto imports
+}
+
+// Looks in the types we loaded and then the ones compiled in.
+type payloadResolver struct {
+	files *protoregistry.Files
+	types *protoregistry.Types
+}
+
+func newPayloadResolver() *payloadResolver {
+	return &payloadResolver{files: new(protoregistry.Files), types: new(protoregistry.Types)}
+}
+
+func (r *payloadResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if mt, err := r.types.FindMessageByName(name); err == nil {
+		return mt, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByName(name)
+}
+
+func (r *payloadResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	if mt, err := r.types.FindMessageByURL(url); err == nil {
+		return mt, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByURL(url)
+}
+
+func (r *payloadResolver) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	if xt, err := r.types.FindExtensionByName(name); err == nil {
+		return xt, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(name)
+}
+
+func (r *payloadResolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	if xt, err := r.types.FindExtensionByNumber(message, field); err == nil {
+		return xt, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
+
+// For protodesc.NewFile
+func (r *payloadResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r *payloadResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// Loads all the configured proto files.
+func (r *payloadResolver) load(p *Protos) error {
+	if p == nil {
+		return nil
+	}
+	var sources []string
+	for _, f := range p.Files {
+		if strings.HasSuffix(f, ".proto") {
+			sources = append(sources, f)
+			continue
+		}
+		if err := r.loadDescriptorSet(f); err != nil {
+			return err
+		}
+	}
+	if len(sources) > 0 {
+		return r.compile(sources, p.ImportPaths)
+	}
+	return nil
+}
+
+func (r *payloadResolver) loadDescriptorSet(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("%s isn't a FileDescriptorSet: %v", path, err)
+	}
+
+	// protoc puts dependencies first with --include_imports.
+	for _, fdp := range set.File {
+		if _, err := r.FindFileByPath(fdp.GetName()); err == nil {
+			continue // compiled in or already loaded
+		}
+		fd, err := protodesc.NewFile(fdp, r)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, fdp.GetName(), err)
+		}
+		if err := r.registerFile(fd); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func (r *payloadResolver) compile(sources, importPaths []string) error {
+	paths := importPaths
+	if len(paths) == 0 {
+		// No import paths, so each source is relative to its own directory.
+		for i, s := range sources {
+			paths = append(paths, filepath.Dir(s))
+			sources[i] = filepath.Base(s)
+		}
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			&protocompile.SourceResolver{ImportPaths: paths},
+			// googleapis and friends that are compiled in
+			protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+				fd, err := r.FindFileByPath(path)
+				if err != nil {
+					return protocompile.SearchResult{}, err
+				}
+				return protocompile.SearchResult{Desc: fd}, nil
+			}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), sources...)
+	if err != nil {
+		return err
+	}
+	for _, fd := range files {
+		if _, err := r.FindFileByPath(fd.Path()); err == nil {
+			continue
+		}
+		if err := r.registerFile(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *payloadResolver) registerFile(fd protoreflect.FileDescriptor) error {
+	if err := r.files.RegisterFile(fd); err != nil {
+		return err
+	}
+	return r.registerMessages(fd.Messages())
+}
+
+func (r *payloadResolver) registerMessages(msgs protoreflect.MessageDescriptors) error {
+	for i := 0; i < msgs.Len(); i++ {
+		md := msgs.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		if _, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err != nil {
+			if err := r.types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+				return err
+			}
+		}
+		if err := r.registerMessages(md.Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
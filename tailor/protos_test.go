@@ -0,0 +1,134 @@
+package tailor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const testProto = `syntax = "proto3";
+package example.v1;
+
+import "google/protobuf/timestamp.proto";
+
+message OrderLog {
+  string order_id = 1;
+  int32 items = 2;
+  google.protobuf.Timestamp placed = 3;
+}
+`
+
+// Makes an AuditLog-style protoPayload out of an OrderLog.
+func orderPayload(t *testing.T, tr *Tailor) logpb.LogEntry_ProtoPayload {
+	t.Helper()
+	mt, err := tr.types.FindMessageByName("example.v1.OrderLog")
+	if err != nil {
+		t.Fatalf("OrderLog wasn't loaded: %v", err)
+	}
+	msg := dynamicpb.NewMessage(mt.Descriptor())
+	fields := mt.Descriptor().Fields()
+	msg.Set(fields.ByName("order_id"), protoreflect.ValueOf("o-123"))
+	msg.Set(fields.ByName("items"), protoreflect.ValueOf(int32(3)))
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logpb.LogEntry_ProtoPayload{ProtoPayload: &anypb.Any{
+		TypeUrl: "type.googleapis.com/example.v1.OrderLog",
+		Value:   value,
+	}}
+}
+
+func TestLoadProtoSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "order.proto")
+	if err := os.WriteFile(src, []byte(testProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pp := logpb.LogEntry_ProtoPayload{ProtoPayload: &anypb.Any{TypeUrl: "type.googleapis.com/example.v1.OrderLog"}}
+	if _, err := newTestTailor(t, &Config{}).decodeProtoPayload(pp); err == nil {
+		t.Fatalf("OrderLog shouldn't be known without loading it")
+	}
+
+	tr := newTestTailor(t, &Config{Protos: &Protos{Files: []string{src}}})
+	got, err := tr.decodeProtoPayload(orderPayload(t, tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"orderId": "o-123", "items": 3.0}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("decodeProtoPayload() = %v; want %v", got, expected)
+	}
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	// Compile the source once to get a descriptor set like protoc would write.
+	dir := t.TempDir()
+	src := filepath.Join(dir, "order.proto")
+	if err := os.WriteFile(src, []byte(testProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compiled := newTestTailor(t, &Config{Protos: &Protos{Files: []string{src}}})
+	fd, err := compiled.types.files.FindFileByPath("order.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(fd)}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := filepath.Join(dir, "order.pb")
+	if err := os.WriteFile(pb, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newTestTailor(t, &Config{Protos: &Protos{Files: []string{pb}}})
+	got, err := tr.decodeProtoPayload(orderPayload(t, tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["orderId"] != "o-123" {
+		t.Errorf("decodeProtoPayload() = %v", got)
+	}
+}
+
+func TestDecodeErrorReportedOnce(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		Common:  []OutputMap{{"method": "protoPayload.methodName"}, {"who": "protoPayload.authenticationInfo.principalEmail"}},
+		Missing: "null",
+	})
+	var errs []error
+	tr.OnError = func(err error) { errs = append(errs, err) }
+
+	for i := 0; i < 2; i++ {
+		entry := &logpb.LogEntry{
+			LogName: "projects/p/logs/app",
+			Payload: &logpb.LogEntry_ProtoPayload{ProtoPayload: &anypb.Any{TypeUrl: "type.googleapis.com/example.v1.Unknown"}},
+		}
+		if _, err := tr.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+		// Like a by: field or a metric label looking at the same entry.
+		tr.Value(entry, "protoPayload.methodName")
+		tr.Value(entry, "protoPayload.serviceName")
+	}
+	if len(errs) != 2 {
+		t.Fatalf("reported %d errors; want one for each entry: %v", len(errs), errs)
+	}
+	var de *DecodeError
+	if !errors.As(errs[0], &de) || de.TypeURL != "type.googleapis.com/example.v1.Unknown" {
+		t.Errorf("error = %v; want a DecodeError", errs[0])
+	}
+}
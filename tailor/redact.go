@@ -0,0 +1,272 @@
+package tailor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const RedactKeyEnv = "LOG_TAILOR_REDACT_KEY"
+const Redacted = "[REDACTED]"
+
+// Config for scrubbing output records.
+type Redaction struct {
+	// HMAC key for the hash action. Falls back to $LOG_TAILOR_REDACT_KEY.
+	Key   string       `yaml:"key"`
+	Rules []RedactRule `yaml:"rules"`
+}
+
+// A rule has either a path into the output record (same syntax as entry
+// paths, ** works like ..) or a pattern (email, ipv4, ipv6, token or
+// a regex). Action is mask, hash or drop.
+type RedactRule struct {
+	Path    string `yaml:"path"`
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"`
+}
+
+var builtinPatterns = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":  `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`,
+	// Loose on purpose. Candidates are checked with net.ParseIP.
+	"ipv6":  `[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:%[0-9A-Za-z]+)?`,
+	"token": `(?i:bearer\s+[A-Za-z0-9._~+/-]+=*)|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|ya29\.[A-Za-z0-9._-]+`,
+}
+
+type redactor struct {
+	key   []byte
+	paths []pathRule
+	pats  []patternRule
+}
+
+type pathRule struct {
+	elems  []pathElem
+	action string
+}
+
+type patternRule struct {
+	re     *regexp.Regexp
+	ipv6   bool
+	action string
+}
+
+// Compiles the redaction config. Returns nil if there's nothing to do.
+func newRedactor(r *Redaction) (*redactor, error) {
+	if r == nil || len(r.Rules) == 0 {
+		return nil, nil
+	}
+	red := &redactor{key: []byte(r.Key)}
+	if len(red.key) == 0 {
+		red.key = []byte(os.Getenv(RedactKeyEnv))
+	}
+
+	for i, rule := range r.Rules {
+		switch rule.Action {
+		case "mask", "drop":
+		case "hash":
+			if len(red.key) == 0 {
+				return nil, fmt.Errorf("redact rule %d: hash needs a key (redact.key or $%s)", i+1, RedactKeyEnv)
+			}
+		default:
+			return nil, fmt.Errorf("redact rule %d: unknown action %q (mask, hash or drop)", i+1, rule.Action)
+		}
+
+		switch {
+		case rule.Path != "" && rule.Pattern != "":
+			return nil, fmt.Errorf("redact rule %d: use path or pattern, not both", i+1)
+		case rule.Path != "":
+			elems, err := parsePath(rule.Path)
+			if err != nil {
+				return nil, err
+			}
+			red.paths = append(red.paths, pathRule{elems: elems, action: rule.Action})
+		case rule.Pattern != "":
+			expr, builtin := builtinPatterns[rule.Pattern]
+			if !builtin {
+				expr = rule.Pattern
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("redact rule %d: %v", i+1, err)
+			}
+			red.pats = append(red.pats, patternRule{re: re, ipv6: rule.Pattern == "ipv6", action: rule.Action})
+		default:
+			return nil, fmt.Errorf("redact rule %d: needs a path or a pattern", i+1)
+		}
+	}
+	return red, nil
+}
+
+// Scrubs the output record in place.
+func (r *redactor) apply(item OutputMap) {
+	for k, v := range item {
+		item[k] = PlainValue(v)
+	}
+	for _, pr := range r.paths {
+		r.applyPath(item, pr.elems, pr.action)
+	}
+	if len(r.pats) > 0 {
+		for k, v := range item {
+			if nv, keep := r.applyPatterns(v); keep {
+				item[k] = nv
+			} else {
+				delete(item, k)
+			}
+		}
+	}
+}
+
+func (r *redactor) applyPath(v any, elems []pathElem, action string) {
+	switch node := v.(type) {
+	case []any:
+		r.applyPathToList(node, elems, action)
+	case map[string]any:
+		r.applyPathToMap(node, elems, action)
+	case OutputMap:
+		r.applyPathToMap(node, elems, action)
+	}
+}
+
+func (r *redactor) applyPathToList(list []any, elems []pathElem, action string) {
+	if len(elems) == 0 {
+		return
+	}
+	head, rest := elems[0], elems[1:]
+	switch head.kind {
+	case indexElem, allElem:
+		for i := range list {
+			if head.kind == indexElem && i != head.index && i != len(list)+head.index {
+				continue
+			}
+			if len(rest) > 0 {
+				r.applyPath(list[i], rest, action)
+			} else {
+				// Dropping from a list would shift the indexes. Mask it instead.
+				list[i] = r.redactValue(list[i], action)
+			}
+		}
+	default:
+		// Otherwise lists don't use up a path element.
+		for _, e := range list {
+			r.applyPath(e, elems, action)
+		}
+	}
+}
+
+func (r *redactor) applyPathToMap(m map[string]any, elems []pathElem, action string) {
+	if len(elems) == 0 {
+		return
+	}
+	head, rest := elems[0], elems[1:]
+
+	if head.kind == descentElem {
+		// Zero elements here, or one and keep going.
+		r.applyPathToMap(m, rest, action)
+		for _, v := range m {
+			r.applyPath(v, elems, action)
+		}
+		return
+	}
+
+	for k, v := range m {
+		if head.kind != wildcardElem && (head.kind != keyElem || head.key != k) {
+			continue
+		}
+		if len(rest) > 0 {
+			r.applyPath(v, rest, action)
+			continue
+		}
+		if action == "drop" {
+			delete(m, k)
+		} else {
+			m[k] = r.redactValue(v, action)
+		}
+	}
+}
+
+// Masks or hashes a whole value. Trees get flattened to JSON first.
+func (r *redactor) redactValue(v any, action string) any {
+	if v == nil {
+		return nil
+	}
+	if action == "mask" {
+		return Redacted
+	}
+	s, ok := v.(string)
+	if !ok {
+		bytes, _ := json.Marshal(v)
+		s = string(bytes)
+	}
+	return r.hash(s)
+}
+
+func (r *redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the scrubbed value and false if it should be dropped.
+func (r *redactor) applyPatterns(v any) (any, bool) {
+	switch node := v.(type) {
+	case string:
+		return r.scrubString(node)
+	case []any:
+		result := make([]any, 0, len(node))
+		for _, e := range node {
+			if nv, keep := r.applyPatterns(e); keep {
+				result = append(result, nv)
+			}
+		}
+		return result, true
+	case map[string]any:
+		for k, e := range node {
+			if nv, keep := r.applyPatterns(e); keep {
+				node[k] = nv
+			} else {
+				delete(node, k)
+			}
+		}
+	case OutputMap:
+		for k, e := range node {
+			if nv, keep := r.applyPatterns(e); keep {
+				node[k] = nv
+			} else {
+				delete(node, k)
+			}
+		}
+	}
+	return v, true
+}
+
+func (r *redactor) scrubString(s string) (any, bool) {
+	for _, p := range r.pats {
+		matched := false
+		s = p.re.ReplaceAllStringFunc(s, func(m string) string {
+			if p.ipv6 && !isIPv6(m) {
+				return m
+			}
+			matched = true
+			if p.action == "hash" {
+				return r.hash(m)
+			}
+			return Redacted
+		})
+		if matched && p.action == "drop" {
+			return nil, false
+		}
+	}
+	return s, true
+}
+
+func isIPv6(s string) bool {
+	s, _, _ = strings.Cut(s, "%")
+	ip := net.ParseIP(s)
+	return ip != nil && strings.Contains(s, ":")
+}
@@ -0,0 +1,95 @@
+package tailor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testRedactor(t *testing.T, rules ...RedactRule) *redactor {
+	t.Helper()
+	r, err := newRedactor(&Redaction{Key: "secret", Rules: rules})
+	if err != nil {
+		t.Fatalf("newRedactor: %v", err)
+	}
+	return r
+}
+
+func TestRedactPaths(t *testing.T) {
+	r := testRedactor(t,
+		RedactRule{Path: "protoPayload.authenticationInfo.principalEmail", Action: "mask"},
+		RedactRule{Path: "protoPayload.*.callerIp", Action: "drop"},
+		RedactRule{Path: "**.token", Action: "mask"},
+	)
+	item := OutputMap{
+		"protoPayload": map[string]any{
+			"authenticationInfo": map[string]any{"principalEmail": "a@example.com"},
+			"requestMetadata":    map[string]any{"callerIp": "10.0.0.1", "callerSuppliedUserAgent": "curl"},
+			"request":            map[string]any{"items": []any{map[string]any{"token": "abc"}}},
+		},
+	}
+	r.apply(item)
+
+	expected := OutputMap{
+		"protoPayload": map[string]any{
+			"authenticationInfo": map[string]any{"principalEmail": Redacted},
+			"requestMetadata":    map[string]any{"callerSuppliedUserAgent": "curl"},
+			"request":            map[string]any{"items": []any{map[string]any{"token": Redacted}}},
+		},
+	}
+	if !reflect.DeepEqual(item, expected) {
+		t.Errorf("apply() = %v; want %v", item, expected)
+	}
+}
+
+func TestRedactPatterns(t *testing.T) {
+	r := testRedactor(t,
+		RedactRule{Pattern: "email", Action: "hash"},
+		RedactRule{Pattern: "ipv4", Action: "mask"},
+		RedactRule{Pattern: "ipv6", Action: "mask"},
+		RedactRule{Pattern: "token", Action: "drop"},
+	)
+	item := OutputMap{
+		"who":   "user a@example.com from 10.1.2.3",
+		"v6":    "came from 2001:db8::1 at 12:30:00",
+		"auth":  "Bearer abc.def",
+		"count": 3,
+	}
+	r.apply(item)
+
+	who := item["who"].(string)
+	if strings.Contains(who, "a@example.com") || !strings.Contains(who, "hmac:") || !strings.HasSuffix(who, "from "+Redacted) {
+		t.Errorf("who = %q", who)
+	}
+	if item["v6"] != "came from "+Redacted+" at 12:30:00" {
+		t.Errorf("v6 = %q", item["v6"])
+	}
+	if _, ok := item["auth"]; ok {
+		t.Errorf("auth should have been dropped")
+	}
+	if item["count"] != 3 {
+		t.Errorf("count = %v; want 3", item["count"])
+	}
+
+	// Same input, same key: same hash. That's the point.
+	again := OutputMap{"who": "user a@example.com from 10.1.2.3"}
+	r.apply(again)
+	if again["who"] != who {
+		t.Errorf("hash isn't stable: %q vs %q", again["who"], who)
+	}
+}
+
+func TestRedactConfigErrors(t *testing.T) {
+	bad := []Redaction{
+		{Rules: []RedactRule{{Path: "a", Action: "hash"}}},
+		{Key: "k", Rules: []RedactRule{{Path: "a", Action: "explode"}}},
+		{Key: "k", Rules: []RedactRule{{Action: "mask"}}},
+		{Key: "k", Rules: []RedactRule{{Pattern: "(", Action: "mask"}}},
+	}
+	t.Setenv(RedactKeyEnv, "")
+	for _, b := range bad {
+		if _, err := newRedactor(&b); err == nil {
+			t.Errorf("newRedactor(%+v) should have failed", b)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package tailor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// A Starlark script that reshapes records. It has to define process:
+//
+//	def process(entry, record):
+//	    record["risk"] = ...
+//	    return record
+//
+// entry is the whole entry as a dict, the same as when there are no
+// outputs. record (which it can leave off) is what the outputs made. It
+// gives back a dict, which becomes the record (in the dict's order), or
+// None to drop the entry. There's no load() and no I/O; the json module
+// is there. print() goes to OnError.
+type Script struct {
+	File   string `yaml:"file"`
+	Source string `yaml:"source"`
+	// Limits for one call of process. A script that goes past them fails
+	// and the entry is dropped. Starlark can't count memory, so what a
+	// script uses while it runs isn't capped. max-size is checked after
+	// it's done: about how many bytes the record it gives back would take
+	// written out.
+	Timeout  time.Duration `yaml:"timeout"`
+	MaxSteps uint64        `yaml:"max-steps"`
+	MaxSize  int           `yaml:"max-size"`
+}
+
+const (
+	DefaultScriptTimeout  = 100 * time.Millisecond
+	DefaultScriptMaxSteps = 1000000
+	DefaultScriptMaxSize  = 1 << 20
+)
+
+// What Record gives back when a script drops the entry.
+var ErrDropped = errors.New("dropped by script")
+
+// A script that failed or went past its limits. The entry isn't output.
+type ScriptError struct {
+	Script string // the file, or "" for a script in the config
+	Err    error
+}
+
+func (e *ScriptError) Error() string {
+	if e.Script == "" {
+		return fmt.Sprintf("script: %v", e.Err)
+	}
+	return fmt.Sprintf("script %s: %v", e.Script, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+type script struct {
+	name       string
+	process    *starlark.Function
+	withRecord bool // process takes the record too
+	timeout    time.Duration
+	maxSteps   uint64
+	maxSize    int
+}
+
+// Loads and runs the script's top level. Gives back nil for a nil Script.
+func compileScript(s *Script) (*script, error) {
+	if s == nil {
+		return nil, nil
+	}
+	name, src := "", s.Source
+	if s.File != "" {
+		if s.Source != "" {
+			return nil, errors.New("script: file or source, not both")
+		}
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("script: %v", err)
+		}
+		name, src = s.File, string(data)
+	}
+	if src == "" {
+		return nil, errors.New("script: needs a file or source")
+	}
+
+	sc := &script{name: name, timeout: s.Timeout, maxSteps: s.MaxSteps, maxSize: s.MaxSize}
+	if sc.timeout <= 0 {
+		sc.timeout = DefaultScriptTimeout
+	}
+	if sc.maxSteps == 0 {
+		sc.maxSteps = DefaultScriptMaxSteps
+	}
+	if sc.maxSize <= 0 {
+		sc.maxSize = DefaultScriptMaxSize
+	}
+
+	thread := sc.thread(nil)
+	timer := time.AfterFunc(sc.timeout, func() { thread.Cancel("timed out") })
+	defer timer.Stop()
+	predeclared := starlark.StringDict{"json": starjson.Module}
+	filename := name
+	if filename == "" {
+		filename = "script"
+	}
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, filename, src, predeclared)
+	if err != nil {
+		return nil, &ScriptError{Script: name, Err: err}
+	}
+	globals.Freeze()
+
+	fn, ok := globals["process"].(*starlark.Function)
+	if !ok {
+		return nil, &ScriptError{Script: name, Err: errors.New("no process function")}
+	}
+	switch fn.NumParams() {
+	case 1:
+	case 2:
+		sc.withRecord = true
+	default:
+		return nil, &ScriptError{Script: name, Err: errors.New("process takes (entry) or (entry, record)")}
+	}
+	sc.process = fn
+	return sc, nil
+}
+
+// A thread with the limits on it. Threads can't be shared, so every call
+// gets one.
+func (sc *script) thread(t *Tailor) *starlark.Thread {
+	thread := &starlark.Thread{Name: "script"}
+	thread.SetMaxExecutionSteps(sc.maxSteps)
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		if t != nil {
+			t.report(&ScriptError{Script: sc.name, Err: errors.New(msg)})
+		}
+	}
+	return thread
+}
+
+// Calls process. A nil record means drop the entry.
+func (sc *script) run(t *Tailor, entry *logpb.LogEntry, rec *Record) (*Record, error) {
+	item, err := t.EntryMap(entry)
+	if err != nil {
+		return nil, err
+	}
+	entryDict, err := toStarlark(item)
+	if err != nil {
+		return nil, &ScriptError{Script: sc.name, Err: err}
+	}
+	args := starlark.Tuple{entryDict}
+	if sc.withRecord {
+		recDict := starlark.NewDict(len(rec.Fields))
+		for _, f := range rec.Fields {
+			v, err := toStarlark(f.Value)
+			if err != nil {
+				return nil, &ScriptError{Script: sc.name, Err: err}
+			}
+			recDict.SetKey(starlark.String(f.Name), v)
+		}
+		args = append(args, recDict)
+	}
+
+	thread := sc.thread(t)
+	timer := time.AfterFunc(sc.timeout, func() { thread.Cancel("timed out") })
+	defer timer.Stop()
+	result, err := starlark.Call(thread, sc.process, args, nil)
+	if err != nil {
+		return nil, &ScriptError{Script: sc.name, Err: err}
+	}
+
+	switch result := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.Dict:
+		if starlarkSize(result, sc.maxSize) > sc.maxSize {
+			return nil, &ScriptError{Script: sc.name, Err: fmt.Errorf("the record is bigger than max-size (%d)", sc.maxSize)}
+		}
+		out := &Record{}
+		for _, kv := range result.Items() {
+			name, ok := starlark.AsString(kv[0])
+			if !ok {
+				return nil, &ScriptError{Script: sc.name, Err: fmt.Errorf("record key %s isn't a string", kv[0])}
+			}
+			v, err := fromStarlark(kv[1])
+			if err != nil {
+				return nil, &ScriptError{Script: sc.name, Err: err}
+			}
+			out.Fields = append(out.Fields, Field{Name: name, Value: v})
+		}
+		return out, nil
+	}
+	return nil, &ScriptError{Script: sc.name, Err: fmt.Errorf("process gave back a %s, not a dict or None", result.Type())}
+}
+
+// About how many bytes v takes written out, or more than limit if it's
+// bigger. A list can hold the same big string many times, so it's added up
+// item by item.
+func starlarkSize(v starlark.Value, limit int) int {
+	n := 0
+	var walk func(v starlark.Value)
+	walk = func(v starlark.Value) {
+		if n > limit {
+			return
+		}
+		switch v := v.(type) {
+		case starlark.String:
+			n += len(v) + 2
+		case *starlark.Dict:
+			n += 2
+			for _, kv := range v.Items() {
+				walk(kv[0])
+				walk(kv[1])
+				n += 2
+			}
+		case starlark.Iterable:
+			n += 2
+			iter := v.Iterate()
+			defer iter.Done()
+			var x starlark.Value
+			for n <= limit && iter.Next(&x) {
+				walk(x)
+				n++
+			}
+		default:
+			n += len(v.String())
+		}
+	}
+	walk(v)
+	return n
+}
+
+func toStarlark(v any) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int32:
+		return starlark.MakeInt64(int64(v)), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(v)), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float32:
+		return starlark.Float(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case protoreflect.Enum:
+		// Severities come out as their names.
+		desc := v.Descriptor().Values().ByNumber(v.Number())
+		if desc == nil {
+			return starlark.MakeInt64(int64(v.Number())), nil
+		}
+		return starlark.String(desc.Name()), nil
+	case proto.Message:
+		return toStarlark(PlainValue(v))
+	case map[string]string:
+		return toStarlark(PlainValue(v))
+	case OutputMap:
+		return toStarlark(map[string]any(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			sv, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			d.SetKey(starlark.String(k), sv)
+		}
+		return d, nil
+	case []any:
+		list := make([]starlark.Value, 0, len(v))
+		for _, child := range v {
+			sv, err := toStarlark(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, sv)
+		}
+		return starlark.NewList(list), nil
+	}
+	// Anything else goes the long way round.
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("can't give a %T to a script: %v", v, err)
+	}
+	var plain any
+	if err := json.Unmarshal(bytes, &plain); err != nil {
+		return nil, err
+	}
+	return toStarlark(plain)
+}
+
+func fromStarlark(v starlark.Value) (any, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return float64(v.Float()), nil
+	case starlark.Float:
+		if math.IsInf(float64(v), 0) || math.IsNaN(float64(v)) {
+			return nil, fmt.Errorf("%v can't be output", v)
+		}
+		return float64(v), nil
+	case *starlark.Dict:
+		m := make(map[string]any, v.Len())
+		for _, kv := range v.Items() {
+			k, ok := starlark.AsString(kv[0])
+			if !ok {
+				k = kv[0].String()
+			}
+			child, err := fromStarlark(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = child
+		}
+		return m, nil
+	case starlark.Iterable:
+		// Lists, tuples and sets
+		var list []any
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			child, err := fromStarlark(x)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, child)
+		}
+		if list == nil {
+			list = []any{}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("a %s can't be output", v.Type())
+}
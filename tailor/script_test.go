@@ -0,0 +1,124 @@
+package tailor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+)
+
+const riskScript = `
+def process(entry, record):
+    risk = 0
+    if entry["severity"] == "ERROR":
+        risk += 1
+    if record["method"].startswith("Delete"):
+        risk += 2
+    return {"method": record["method"], "risk": risk, "labels": entry["labels"]}
+`
+
+func TestScript(t *testing.T) {
+	entry := &logpb.LogEntry{
+		LogName:  "projects/p/logs/app",
+		Severity: ltype.LogSeverity_ERROR,
+		Labels:   map[string]string{"team": "a"},
+		Payload:  &logpb.LogEntry_TextPayload{TextPayload: "DeleteThing"},
+	}
+	tr := newTestTailor(t, &Config{
+		Common: []OutputMap{{"method": "textPayload"}},
+		Script: &Script{Source: riskScript},
+	})
+	rec, err := tr.Record(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Field{
+		{Name: "method", Value: "DeleteThing"},
+		{Name: "risk", Value: int64(3)},
+		{Name: "labels", Value: map[string]any{"team": "a"}},
+	}
+	if !reflect.DeepEqual(rec.Fields, expected) {
+		t.Errorf("record = %v; want %v", rec.Fields, expected)
+	}
+
+	// A log's own script wins over the global one.
+	tr = newTestTailor(t, &Config{
+		Script: &Script{Source: riskScript},
+		Logs: []Log{{Name: "app", Script: &Script{Source: `
+def process(entry):
+    if entry["textPayload"] == "DeleteThing":
+        return None
+    return {"text": entry["textPayload"]}
+`}}},
+	})
+	if _, err := tr.Record(entry); !errors.Is(err, ErrDropped) {
+		t.Errorf("expected the entry to be dropped, got %v", err)
+	}
+	entry.Payload = &logpb.LogEntry_TextPayload{TextPayload: "GetThing"}
+	rec, err = tr.Record(entry)
+	if err != nil || !reflect.DeepEqual(rec.Fields, []Field{{Name: "text", Value: "GetThing"}}) {
+		t.Errorf("record = %v, %v", rec, err)
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	entry := &logpb.LogEntry{LogName: "projects/p/logs/app"}
+	tests := []struct {
+		name   string
+		script *Script
+		errMsg string
+	}{
+		{
+			name:   "steps",
+			script: &Script{Source: "def process(entry):\n    for i in range(100000000):\n        pass\n", MaxSteps: 1000},
+			errMsg: "too many steps",
+		},
+		{
+			name:   "timeout",
+			script: &Script{Source: "def process(entry):\n    for i in range(100000000):\n        pass\n", Timeout: time.Millisecond, MaxSteps: 1 << 62},
+			errMsg: "timed out",
+		},
+		{
+			name:   "too big",
+			script: &Script{Source: "def process(entry):\n    return {'big': ['x' * 1000] * 2000}\n"},
+			errMsg: "max-size",
+		},
+		{
+			name:   "fails",
+			script: &Script{Source: "def process(entry):\n    return entry['nope']\n"},
+			errMsg: "nope",
+		},
+		{
+			name:   "bad result",
+			script: &Script{Source: "def process(entry):\n    return 1\n"},
+			errMsg: "not a dict or None",
+		},
+	}
+	for _, tt := range tests {
+		tr := newTestTailor(t, &Config{Script: tt.script})
+		_, err := tr.Record(entry)
+		var se *ScriptError
+		if !errors.As(err, &se) || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: expected a script error with %q, got %v", tt.name, tt.errMsg, err)
+		}
+	}
+}
+
+func TestBadScripts(t *testing.T) {
+	for _, s := range []*Script{
+		{},
+		{Source: "def process(:"},
+		{Source: "x = 1"},
+		{Source: "def process(a, b, c):\n    return a\n"},
+		{Source: "load('x.star', 'y')\ndef process(e):\n    return e\n"},
+		{File: "/nonexistent/script.star"},
+	} {
+		if _, err := New(&Config{Script: s}); err == nil {
+			t.Errorf("New should have failed for %+v", s)
+		}
+	}
+}
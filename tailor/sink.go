@@ -0,0 +1,141 @@
+package tailor
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The sinks here are safe for use by multiple goroutines. Records don't
+// get mixed up with each other. Closing them flushes; the writer they were
+// given is left open.
+
+// Writes records as JSON, one per line.
+type JSONLSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{w: bufio.NewWriter(w)}
+}
+
+func (s *JSONLSink) Open() error {
+	return nil
+}
+
+func (s *JSONLSink) Write(rec *Record) error {
+	bytes, err := json.Marshal(rec.Map())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintf(s.w, "%s\n", bytes)
+	return err
+}
+
+func (s *JSONLSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *JSONLSink) Close() error {
+	return s.Flush()
+}
+
+// Writes records as YAML documents with the fields in order.
+type YAMLSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func NewYAMLSink(w io.Writer) *YAMLSink {
+	return &YAMLSink{w: bufio.NewWriter(w)}
+}
+
+func (s *YAMLSink) Open() error {
+	return nil
+}
+
+func (s *YAMLSink) Write(rec *Record) error {
+	ordered := make(yaml.MapSlice, 0, len(rec.Fields))
+	for _, f := range rec.Fields {
+		ordered = append(ordered, yaml.MapItem{Key: f.Name, Value: f.Value})
+	}
+	bytes, err := yaml.Marshal(ordered)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintf(s.w, "---\n%s", bytes)
+	return err
+}
+
+func (s *YAMLSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *YAMLSink) Close() error {
+	return s.Flush()
+}
+
+// Writes records as CSV rows with a column for each name given (see
+// Tailor.Columns). Trees are written as JSON so they can go in JSON
+// columns.
+type CSVSink struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	columns []string
+}
+
+func NewCSVSink(w io.Writer, columns []string) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w), columns: columns}
+}
+
+func (s *CSVSink) Open() error {
+	return nil
+}
+
+func (s *CSVSink) Write(rec *Record) error {
+	var row []string
+	for _, k := range s.columns {
+		v, _ := rec.Get(k)
+		switch v := v.(type) {
+		case nil:
+			// Missing or null. An empty column is NULL to most databases.
+			row = append(row, "")
+		case string:
+			row = append(row, v)
+		default:
+			bytes, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("marshaling %s: %v", k, err)
+			}
+			row = append(row, string(bytes))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(row)
+}
+
+func (s *CSVSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *CSVSink) Close() error {
+	return s.Flush()
+}
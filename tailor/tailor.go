@@ -0,0 +1,803 @@
+// Package tailor turns Cloud Logging entries into records shaped by a
+// config: which fields to pull out of each entry, what to call them and
+// what to do with them on the way. It's the engine behind the log-tailor
+// command and can be used on its own:
+//
+//	t, err := tailor.New(&tailor.Config{
+//		Common: []tailor.OutputMap{{"severity": "severity"}},
+//	})
+//	...
+//	rec, err := t.Record(entry)
+//
+// A Tailor has no state that changes after New (apart from the enrich:
+// tables it rereads and the protoPayloads it has reported it can't decode,
+// which are looked after safely), so one can be shared by as many
+// goroutines as you like.
+package tailor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	// To get the proto defs
+	_ "google.golang.org/genproto/googleapis/cloud/audit"
+	_ "google.golang.org/genproto/googleapis/iam/v1/logging"
+)
+
+// Where entries come from. Run sends entries on ch until ctx is done or
+// there aren't any more. It doesn't close ch.
+type Source interface {
+	Run(ctx context.Context, ch chan<- *logpb.LogEntry) error
+}
+
+// Where records go. Open is called before the first Write and Close
+// after the last one. Flush pushes out anything buffered and gives back
+// any error writing it.
+type Sink interface {
+	Open() error
+	Write(rec *Record) error
+	Flush() error
+	Close() error
+}
+
+// Turns entries into records that come out later, like one record for a
+// window of them. Add takes an entry or gives back why it didn't, Advance
+// gives back the records that are ready by now and Close gives back the
+// rest.
+type Collector interface {
+	Add(entry *logpb.LogEntry) (bool, string)
+	Advance(now time.Time) []*Record
+	Close() []*Record
+}
+
+// The Collector the config asks for, or nil if entries are output one by
+// one. Like a Limiter, make one for each stream of entries.
+func NewCollector(t *Tailor) Collector {
+	switch {
+	case t.config.Aggregate != nil:
+		return NewAggregator(t)
+	case t.config.Traces != nil:
+		return NewTraceGrouper(t)
+	}
+	return nil
+}
+
+// How often Pipe advances a Collector.
+const CollectorTick = time.Second
+
+// A tailored entry. Fields are in config order, or sorted by name when
+// there were no outputs for the entry and it's all there.
+type Record struct {
+	Fields []Field
+}
+
+type Field struct {
+	Name  string
+	Value any
+}
+
+func (r *Record) Get(name string) (any, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Replaces the field's value or adds it at the end.
+func (r *Record) Set(name string, v any) {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			r.Fields[i].Value = v
+			return
+		}
+	}
+	r.Fields = append(r.Fields, Field{Name: name, Value: v})
+}
+
+func (r *Record) Map() map[string]any {
+	m := make(map[string]any, len(r.Fields))
+	for _, f := range r.Fields {
+		m[f.Name] = f.Value
+	}
+	return m
+}
+
+// A protoPayload that couldn't be decoded.
+type DecodeError struct {
+	TypeURL string
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding protoPayload %s: %v", e.TypeURL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type Tailor struct {
+	config    *Config
+	parsers   map[string][]*textParser
+	redactor  *redactor
+	enrichers []*enricher
+	geo       *geoLocator
+	alerts    []*alertRule
+	common    []outputField
+	outputs   [][]outputField // for each of config.Logs
+	types     *payloadResolver
+	script    *script   // the global one
+	scripts   []*script // for each of config.Logs
+	failed    *decodeFailures
+
+	// Called with problems that don't stop a record from being made, like
+	// a *DecodeError or a transform that failed. Set it before use.
+	OnError func(err error)
+}
+
+// Compiles the config. The Tailor keeps it, so don't change it afterwards.
+func New(c *Config) (*Tailor, error) {
+	t := &Tailor{config: c, types: newPayloadResolver(), failed: newDecodeFailures()}
+	if !ValidMissingPolicy(c.Missing) {
+		return nil, fmt.Errorf("invalid missing policy: %s", c.Missing)
+	}
+	var err error
+	if t.parsers, err = newTextParsers(c.Parsers); err != nil {
+		return nil, err
+	}
+	if t.redactor, err = newRedactor(c.Redact); err != nil {
+		return nil, err
+	}
+	if c.Aggregate != nil && c.Traces != nil {
+		return nil, errors.New("aggregate and traces can't both be used")
+	}
+	if c.Aggregate != nil {
+		if err := CheckAggregate(c.Aggregate); err != nil {
+			return nil, err
+		}
+	}
+	if c.Traces != nil {
+		if err := CheckTraces(c.Traces); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.types.load(c.Protos); err != nil {
+		return nil, fmt.Errorf("loading protos: %v", err)
+	}
+	for _, def := range c.Enrich {
+		e, err := newEnricher(def)
+		if err != nil {
+			return nil, err
+		}
+		t.enrichers = append(t.enrichers, e)
+	}
+	if t.geo, err = newGeoLocator(c.GeoIP); err != nil {
+		return nil, err
+	}
+	for _, r := range c.Alerts {
+		rule, err := compileAlertRule(r)
+		if err != nil {
+			return nil, err
+		}
+		t.alerts = append(t.alerts, rule)
+	}
+	if t.common, err = compileOutputs(c.Common); err != nil {
+		return nil, err
+	}
+	t.outputs = make([][]outputField, len(c.Logs))
+	for i, l := range c.Logs {
+		if t.outputs[i], err = compileOutputs(l.Output); err != nil {
+			return nil, fmt.Errorf("log %s: %w", l.Name, err)
+		}
+	}
+	if t.script, err = compileScript(c.Script); err != nil {
+		return nil, err
+	}
+	t.scripts = make([]*script, len(c.Logs))
+	for i, l := range c.Logs {
+		if t.scripts[i], err = compileScript(l.Script); err != nil {
+			return nil, fmt.Errorf("log %s: %w", l.Name, err)
+		}
+	}
+	return t, nil
+}
+
+func (t *Tailor) Config() *Config {
+	return t.config
+}
+
+func (t *Tailor) report(err error) {
+	if t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// Tailors everything from src and writes it to sink until src is done.
+// Entries the match rule drops are skipped, and so are entries the
+// missing-value policy fails (they go to OnError), and so are those the
+// logs' sample: and rate-limit: settings turn away. It opens and closes
+// the sink. With a Collector, its records are written instead of the
+// entries'.
+func (t *Tailor) Pipe(ctx context.Context, src Source, sink Sink) (err error) {
+	if err := sink.Open(); err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ch := make(chan *logpb.LogEntry)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- src.Run(ctx, ch)
+		close(ch)
+	}()
+
+	limiter := NewLimiter(t)
+	collector := NewCollector(t)
+	write := func(recs []*Record) error {
+		for _, rec := range recs {
+			if err := sink.Write(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ticker := time.NewTicker(CollectorTick)
+	defer ticker.Stop()
+entries:
+	for {
+		var entry *logpb.LogEntry
+		select {
+		case now := <-ticker.C:
+			if collector != nil {
+				if err := write(collector.Advance(now)); err != nil {
+					return err
+				}
+			}
+			continue
+		case e, ok := <-ch:
+			if !ok {
+				break entries
+			}
+			entry = e
+		}
+		if !t.Match(entry) {
+			continue
+		}
+		if ok, _ := limiter.Allow(entry); !ok {
+			continue
+		}
+		if collector != nil {
+			collector.Add(entry)
+			continue
+		}
+		rec, err := t.Record(entry)
+		if errors.Is(err, ErrDropped) {
+			continue
+		}
+		if err != nil {
+			t.report(err)
+			continue
+		}
+		if err := sink.Write(rec); err != nil {
+			return err
+		}
+	}
+	if collector != nil {
+		if err := write(collector.Close()); err != nil {
+			return err
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// False if the match rule says the entry shouldn't be output.
+func (t *Tailor) Match(entry *logpb.LogEntry) bool {
+	if t.config.MatchRule == "drop-no-match" {
+		for _, log := range t.config.Logs {
+			lnMatch := LogName(entry) == log.Name
+			if !lnMatch {
+				continue
+			}
+			typeMatch := true
+			if log.ResType != "" {
+				typeMatch = entry.Resource.Type == log.ResType
+			}
+			if typeMatch {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Builds the output record. An error means the missing-value policy says
+// the entry shouldn't be output at all, or it's ErrDropped because a
+// script said so, or a *ScriptError.
+func (t *Tailor) Record(entry *logpb.LogEntry) (*Record, error) {
+	item := make(OutputMap)
+	lname := LogName(entry)
+	var match *Log
+	script := t.script
+	memo := parseMemo{}
+
+	if err := t.addOutputToItem(t.common, item, entry, memo); err != nil {
+		return nil, err
+	}
+
+	// Find the first matching log and use its outputs
+	for i := 0; i < len(t.config.Logs); i++ {
+		log := &t.config.Logs[i]
+		if lname == log.Name {
+			if log.ResType != "" && log.ResType != entry.Resource.Type {
+				continue
+			}
+			if t.scripts[i] != nil && script == t.script {
+				script = t.scripts[i]
+			}
+			if len(log.Output) > 0 {
+				if err := t.addOutputToItem(t.outputs[i], item, entry, memo); err != nil {
+					return nil, err
+				}
+				match = log
+				break
+			}
+		}
+	}
+
+	rec := &Record{}
+	if len(item) == 0 {
+		// There were no outputs specified so we use all the data
+		if err := t.addEntryToItem(item, entry, memo); err != nil {
+			return nil, err
+		}
+		var names []string
+		for name := range item {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rec.Fields = append(rec.Fields, Field{Name: name, Value: item[name]})
+		}
+	} else {
+		// In the order found in the config.
+		outputs := t.config.Common
+		if match != nil {
+			outputs = append(outputs[:len(outputs):len(outputs)], match.Output...)
+		}
+		for _, om := range outputs {
+			name := fieldName(om)
+			if v, ok := item[name]; ok { // not omitted by the missing-value policy
+				rec.Set(name, v)
+			}
+		}
+	}
+
+	if len(t.enrichers) > 0 {
+		now := time.Now()
+		for _, e := range t.enrichers {
+			e.apply(t, entry, memo, rec, now)
+		}
+	}
+	if t.geo != nil {
+		t.geo.apply(t, entry, memo, rec)
+	}
+	if script != nil {
+		var err error
+		if rec, err = script.run(t, entry, rec); err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, ErrDropped
+		}
+	}
+	if t.redactor != nil {
+		t.redact(rec)
+	}
+	return rec, nil
+}
+
+// Redacts the record, keeping its order.
+func (t *Tailor) redact(rec *Record) {
+	item := OutputMap(rec.Map())
+	t.redactor.apply(item)
+	fields := rec.Fields[:0]
+	for _, f := range rec.Fields {
+		if v, ok := item[f.Name]; ok {
+			fields = append(fields, Field{Name: f.Name, Value: v})
+		}
+	}
+	rec.Fields = fields
+}
+
+// The whole entry as a map, the way it's output when there are no outputs
+// for it. Proto messages in it are left as they are.
+func (t *Tailor) EntryMap(entry *logpb.LogEntry) (OutputMap, error) {
+	item := make(OutputMap)
+	if err := t.addEntryToItem(item, entry, nil); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Every output name in the config: the common ones, then each log's and
+// then the enrich: and geoip: columns. They're the columns for CSV. With
+// aggregate: or traces: it's the fields of their records.
+func (t *Tailor) Columns() []string {
+	switch {
+	case t.config.Aggregate != nil:
+		return aggregateColumns(t.config.Aggregate)
+	case t.config.Traces != nil:
+		return traceColumns
+	}
+	var columns []string
+	for _, om := range t.config.Common {
+		columns = append(columns, fieldName(om))
+	}
+	for _, l := range t.config.Logs {
+		for _, om := range l.Output {
+			columns = append(columns, fieldName(om))
+		}
+	}
+	for _, e := range t.enrichers {
+		columns = append(columns, e.columns()...)
+	}
+	if t.geo != nil {
+		columns = append(columns, t.geo.columns()...)
+	}
+	return columns
+}
+
+// With a memo, the protoPayload is copied so the item doesn't share it.
+func (t *Tailor) addEntryToItem(item OutputMap, entry *logpb.LogEntry, memo parseMemo) error {
+	val := reflect.ValueOf(entry.Payload)
+
+	switch entry.Payload.(type) {
+	case *logpb.LogEntry_ProtoPayload:
+		pp := val.Elem().Interface().(logpb.LogEntry_ProtoPayload)
+		payload := t.protoPayload(pp, memo)
+		if payload != nil && memo != nil {
+			payload = copyParsed(payload).(map[string]any)
+		}
+		if payload != nil {
+			item["protoPayload"] = payload
+		} else if err := t.setMissing(item, "protoPayload", nil, errors.New("protoPayload could not be decoded")); err != nil {
+			return err
+		}
+
+	case *logpb.LogEntry_JsonPayload:
+		jp := val.Elem().Interface().(logpb.LogEntry_JsonPayload)
+		item["jsonPayload"] = jp.JsonPayload.AsMap()
+
+	case *logpb.LogEntry_TextPayload:
+		tp := val.Elem().Interface().(logpb.LogEntry_TextPayload)
+		item["textPayload"] = tp.TextPayload
+
+	default:
+		item["payload"] = entry.Payload
+	}
+
+	fixedLogName, _ := url.PathUnescape(entry.LogName)
+	item["logName"] = fixedLogName
+	if entry.Resource != nil {
+		item["resource"] = entry.Resource
+	}
+	item["timestamp"] = entry.Timestamp.AsTime().Format(time.RFC3339Nano)
+	item["receiveTimestamp"] = entry.ReceiveTimestamp.AsTime().Format(time.RFC3339Nano)
+	item["severity"] = entry.Severity
+	item["insertId"] = entry.InsertId
+	if entry.HttpRequest != nil {
+		item["httpRequest"] = entry.HttpRequest
+	}
+	item["labels"] = entry.Labels
+	if entry.Operation != nil {
+		item["operation"] = entry.Operation
+	}
+	item["trace"] = entry.Trace
+	item["spanid"] = entry.SpanId
+	item["tracesampled"] = entry.TraceSampled
+	if entry.SourceLocation != nil {
+		item["sourcelocation"] = entry.SourceLocation
+	}
+	if entry.Split != nil {
+		item["split"] = entry.Split
+	}
+	return nil
+}
+
+// An output field from the config, compiled: a path, a field spec or a
+// nested map of fields.
+type outputField struct {
+	name   string
+	path   string
+	spec   *fieldSpec
+	nested bool
+	fields []outputField
+}
+
+// A src path with a regex/value rewrite, a transform list and/or
+// missing:/default:.
+type fieldSpec struct {
+	om         OutputMap // for missing: and default:
+	src        string
+	re         *regexp.Regexp // nil without regex: and value:
+	value      string
+	transforms []transform
+}
+
+// Values that aren't paths or maps are left out, like they always were.
+func compileOutputs(outputs []OutputMap) ([]outputField, error) {
+	var fields []outputField
+	for _, om := range outputs {
+		name := fieldName(om)
+		if f, err := compileOutput(name, om[name]); err != nil {
+			return nil, err
+		} else if f != nil {
+			fields = append(fields, *f)
+		}
+	}
+	return fields, nil
+}
+
+func compileOutput(name string, oi any) (*outputField, error) {
+	switch oi := oi.(type) {
+	case string:
+		return &outputField{name: name, path: oi}, nil
+	case OutputMap:
+		if !IsFieldSpec(oi) {
+			f := &outputField{name: name, nested: true}
+			for k, v := range oi {
+				if sub, err := compileOutput(k, v); err != nil {
+					return nil, err
+				} else if sub != nil {
+					f.fields = append(f.fields, *sub)
+				}
+			}
+			return f, nil
+		}
+		spec := &fieldSpec{om: oi, src: strVal(oi, "src")}
+		if hasKeys(oi, "regex", "value") {
+			re, err := regexp.Compile(strVal(oi, "regex"))
+			if err != nil {
+				return nil, fmt.Errorf("output %s: bad regex: %v", name, err)
+			}
+			spec.re, spec.value = re, strVal(oi, "value")
+		}
+		if ts, ok := oi["transform"]; ok {
+			var err error
+			if spec.transforms, err = compileTransforms(ts); err != nil {
+				return nil, fmt.Errorf("output %s: %v", name, err)
+			}
+		}
+		return &outputField{name: name, spec: spec}, nil
+	}
+	return nil, nil
+}
+
+func (t *Tailor) addOutputToItem(outputs []outputField, item OutputMap, entry *logpb.LogEntry, memo parseMemo) error {
+	for _, f := range outputs {
+		if err := t.addToItem(f, item, entry, memo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tailor) addToItem(f outputField, item OutputMap, entry *logpb.LogEntry, memo parseMemo) error {
+	switch {
+	case f.spec != nil:
+		v, err := t.fieldSpecValue(f.spec, entry, memo)
+		if err != nil {
+			return t.setMissing(item, f.name, f.spec.om, err)
+		}
+		item[f.name] = v
+	case f.nested:
+		newItem := OutputMap{}
+		item[f.name] = newItem
+		for _, sub := range f.fields {
+			if err := t.addToItem(sub, newItem, entry, memo); err != nil {
+				return err
+			}
+		}
+	default:
+		val, err := t.value(entry, f.path, memo)
+		if err != nil {
+			return t.setMissing(item, f.name, nil, err)
+		}
+		item[f.name] = val
+	}
+	return nil
+}
+
+// Gets the value for a src/regex/value/transform output field. An error
+// means there's no value and the missing-value policy applies.
+func (t *Tailor) fieldSpecValue(spec *fieldSpec, entry *logpb.LogEntry, memo parseMemo) (any, error) {
+	v, missing := t.value(entry, spec.src, memo)
+	if missing == nil && spec.re != nil {
+		if src, ok := v.(string); ok {
+			v = regexVal(src, spec.re, spec.value)
+		}
+	}
+	if len(spec.transforms) == 0 {
+		return v, missing
+	}
+	tv, err := applyTransforms(v, spec.transforms)
+	if missing != nil {
+		// A default: step can fill in a missing value.
+		if err == nil && tv != nil {
+			return tv, nil
+		}
+		return nil, missing
+	}
+	if err != nil {
+		t.report(fmt.Errorf("transforming %s: %v", spec.src, err))
+	}
+	return tv, err
+}
+
+// What to do with an output field that has no value. The field can override
+// the global missing: policy with its own missing: and default: keys. An
+// error means the whole entry gets dropped.
+func (t *Tailor) setMissing(item OutputMap, name string, om OutputMap, cause error) error {
+	action, def := t.config.Missing, t.config.MissingDefault
+	if om != nil {
+		if d, ok := om["default"]; ok {
+			action, def = "default", d
+		}
+		if a := strVal(om, "missing"); a != "" {
+			action = a
+		}
+	}
+
+	switch action {
+	case "omit":
+	case "default":
+		item[name] = def
+	case "fail":
+		return fmt.Errorf("%s: %v", name, cause)
+	default:
+		item[name] = nil
+	}
+	return nil
+}
+
+var logNameRE = regexp.MustCompile("^.*/(.*)$")
+
+// The short name of the entry's log, unescaped: cloudaudit.googleapis.com/activity
+func LogName(entry *logpb.LogEntry) string {
+	m := logNameRE.FindStringSubmatch(entry.LogName)
+	if m == nil {
+		return entry.LogName
+	}
+	fixed, _ := url.PathUnescape(m[1])
+	return fixed
+}
+
+// Decodes the protoPayload into a map, or gets it from the memo, which can
+// be nil. Decode errors go to OnError, once for each payload however many
+// paths go into it, never in the output, and give back nil.
+func (t *Tailor) protoPayload(pp logpb.LogEntry_ProtoPayload, memo parseMemo) map[string]any {
+	key := parseKey{payload: pp.ProtoPayload}
+	if p, ok := memo[key]; ok {
+		if p.err != nil {
+			return nil
+		}
+		return p.val.Interface().(map[string]any)
+	}
+	payload, err := t.decodeProtoPayload(pp)
+	if memo != nil {
+		memo[key] = parsedString{val: reflect.ValueOf(payload), err: err}
+	}
+	if err != nil {
+		if t.failed.first(pp.ProtoPayload) {
+			t.report(&DecodeError{TypeURL: pp.ProtoPayload.TypeUrl, Err: err})
+		}
+		return nil
+	}
+	return payload
+}
+
+// How many of the protoPayloads that couldn't be decoded are remembered.
+// Everything that looks into an entry does so before a worker moves on to
+// the next one, so only the last few are needed.
+const decodeFailuresKept = 256
+
+// The protoPayloads that couldn't be decoded lately, so each is only
+// reported once.
+type decodeFailures struct {
+	mu    sync.Mutex
+	seen  map[*anypb.Any]bool
+	order []*anypb.Any
+}
+
+func newDecodeFailures() *decodeFailures {
+	return &decodeFailures{seen: map[*anypb.Any]bool{}}
+}
+
+// True the first time it's called for the payload.
+func (d *decodeFailures) first(payload *anypb.Any) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen[payload] {
+		return false
+	}
+	if len(d.order) == decodeFailuresKept {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.seen[payload] = true
+	d.order = append(d.order, payload)
+	return true
+}
+
+func (t *Tailor) decodeProtoPayload(pp logpb.LogEntry_ProtoPayload) (map[string]any, error) {
+	typeURL := pp.ProtoPayload.TypeUrl
+	messageName := getMessageNameFromTypeURL(typeURL)
+	if messageName == "" {
+		return nil, fmt.Errorf("invalid type URL: %s", typeURL)
+	}
+	fullName := protoreflect.FullName(messageName)
+	messageType, err := t.types.FindMessageByName(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("message type not found: %v", err)
+	}
+
+	// Obtain the Message Descriptor from the Message Type
+	desc := messageType.Descriptor()
+
+	// Create a dynamic message based on the descriptor
+	msg := dynamicpb.NewMessage(desc)
+
+	// Unmarshal the value into the dynamic message
+	unmarshal := proto.UnmarshalOptions{Resolver: t.types}
+	if err := unmarshal.Unmarshal(pp.ProtoPayload.Value, msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into dynamic message: %v", err)
+	}
+
+	jsonBytes, err := protojson.MarshalOptions{Resolver: t.types}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal dynamic message to JSON: %v", err)
+	}
+
+	var payloadMap map[string]any
+	err = json.Unmarshal(jsonBytes, &payloadMap)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal JSON to map: %v", err)
+	}
+
+	return payloadMap, nil
+}
+
+func getMessageNameFromTypeURL(typeURL string) string {
+	const prefix = "type.googleapis.com/"
+	if !strings.HasPrefix(typeURL, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(typeURL, prefix)
+}
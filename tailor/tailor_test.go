@@ -0,0 +1,183 @@
+package tailor
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+func newTestTailor(t *testing.T, c *Config) *Tailor {
+	t.Helper()
+	tr, err := New(c)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tr.OnError = func(err error) { t.Logf("OnError: %v", err) }
+	return tr
+}
+
+func fieldNames(rec *Record) []string {
+	var names []string
+	for _, f := range rec.Fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestRecordOrder(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		Common: []OutputMap{{"sev": "severity"}, {"id": "insertId"}},
+		Logs: []Log{{Name: "app", Output: []OutputMap{
+			{"zone": "resource.labels.zone"},
+			{"project": "resource.labels.project_id"},
+		}}},
+	})
+	entry := &logpb.LogEntry{
+		LogName:  "projects/p/logs/app",
+		InsertId: "abc",
+		Resource: &mrpb.MonitoredResource{Labels: map[string]string{"zone": "z", "project_id": "p"}},
+	}
+	rec, err := tr.Record(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"sev", "id", "zone", "project"}
+	if got := fieldNames(rec); !reflect.DeepEqual(got, expected) {
+		t.Errorf("fields = %v; want %v", got, expected)
+	}
+	if v, _ := rec.Get("project"); v != "p" {
+		t.Errorf("project = %v", v)
+	}
+
+	// No outputs: the whole entry, sorted by name.
+	rec, err = newTestTailor(t, &Config{}).Record(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := fieldNames(rec)
+	for i := 1; i < len(names); i++ {
+		if names[i-1] > names[i] {
+			t.Fatalf("full entry fields aren't sorted: %v", names)
+		}
+	}
+}
+
+func TestBadOutputs(t *testing.T) {
+	for _, c := range []*Config{
+		{Common: []OutputMap{{"x": OutputMap{"src": "logName", "regex": "(", "value": "$1"}}}},
+		{Common: []OutputMap{{"x": OutputMap{"src": "logName", "transform": []any{"shout"}}}}},
+		{Logs: []Log{{Name: "app", Output: []OutputMap{{"nested": OutputMap{"x": OutputMap{"src": "logName", "regex": "[", "value": "$1"}}}}}}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%v) should have failed", c)
+		}
+	}
+
+	tr := newTestTailor(t, &Config{Common: []OutputMap{
+		{"log": OutputMap{"src": "logName", "regex": `logs/(.*)`, "value": "$1", "transform": []any{"uppercase"}}},
+	}})
+	rec, err := tr.Record(&logpb.LogEntry{LogName: "projects/p/logs/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := rec.Get("log"); v != "APP" {
+		t.Errorf("log = %v; want APP", v)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tr := newTestTailor(t, &Config{MatchRule: "drop-no-match", Logs: []Log{{Name: "app", ResType: "gce_instance"}}})
+	tests := []struct {
+		log, resType string
+		expected     bool
+	}{
+		{log: "projects/p/logs/app", resType: "gce_instance", expected: true},
+		{log: "projects/p/logs/app", resType: "k8s_container", expected: false},
+		{log: "projects/p/logs/other", resType: "gce_instance", expected: false},
+	}
+	for _, tt := range tests {
+		entry := &logpb.LogEntry{LogName: tt.log, Resource: &mrpb.MonitoredResource{Type: tt.resType}}
+		if got := tr.Match(entry); got != tt.expected {
+			t.Errorf("Match(%s, %s) = %v; want %v", tt.log, tt.resType, got, tt.expected)
+		}
+	}
+}
+
+type sliceSource []*logpb.LogEntry
+
+func (s sliceSource) Run(ctx context.Context, ch chan<- *logpb.LogEntry) error {
+	for _, e := range s {
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func TestPipe(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		MatchRule: "drop-no-match",
+		Logs:      []Log{{Name: "app", Output: []OutputMap{{"id": "insertId"}, {"trace": "trace"}}}},
+	})
+	src := sliceSource{
+		{LogName: "projects/p/logs/app", InsertId: "1", Trace: "t1"},
+		{LogName: "projects/p/logs/other", InsertId: "2"},
+		{LogName: "projects/p/logs/app", InsertId: "3", Trace: "t3"},
+	}
+	var out bytes.Buffer
+	if err := tr.Pipe(context.Background(), src, NewJSONLSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"1","trace":"t1"}` + "\n" + `{"id":"3","trace":"t3"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Pipe wrote %q; want %q", out.String(), expected)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	rec := &Record{Fields: []Field{
+		{Name: "b", Value: "x,y"},
+		{Name: "a", Value: map[string]any{"k": 1}},
+		{Name: "c", Value: nil},
+	}}
+	tests := []struct {
+		name     string
+		sink     func(*bytes.Buffer) Sink
+		expected string
+	}{
+		{
+			name:     "jsonl",
+			sink:     func(b *bytes.Buffer) Sink { return NewJSONLSink(b) },
+			expected: `{"a":{"k":1},"b":"x,y","c":null}` + "\n",
+		},
+		{
+			name:     "yaml",
+			sink:     func(b *bytes.Buffer) Sink { return NewYAMLSink(b) },
+			expected: "---\nb: x,y\na:\n  k: 1\nc: null\n",
+		},
+		{
+			name:     "csv",
+			sink:     func(b *bytes.Buffer) Sink { return NewCSVSink(b, []string{"a", "b", "c", "d"}) },
+			expected: `"{""k"":1}","x,y",,` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		sink := tt.sink(&out)
+		if err := sink.Write(rec); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := sink.Flush(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%s sink wrote %q; want %q", tt.name, out.String(), tt.expected)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package tailor
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+)
+
+// Holds entries with the same trace until no more have come for a while
+// and then outputs them as one record.
+type Traces struct {
+	// How long after its last entry a trace is output.
+	// DefaultTraceIdle if 0.
+	Idle time.Duration `yaml:"idle"`
+	// Path to the service that wrote an entry. DefaultTraceService if "".
+	Service string `yaml:"service"`
+	// Traces held at once. The one that's been idle longest is output
+	// early to make room.
+	MaxTraces int `yaml:"max-traces"`
+	// Entries kept for one trace. Entries past it are dropped.
+	MaxEntries int `yaml:"max-entries"`
+}
+
+const (
+	DefaultTraceIdle       = 10 * time.Second
+	DefaultTraceService    = "resource.labels.service_name" // Cloud Run
+	DefaultTraceMaxTraces  = 10000
+	DefaultTraceMaxEntries = 1000
+)
+
+// Why a TraceGrouper didn't take an entry, besides the reasons Record
+// fails.
+const (
+	NoTrace     = "no-trace"
+	TraceTooBig = "trace-too-big"
+)
+
+// The fields of a trace's record.
+var traceColumns = []string{"trace", "start", "end", "duration", "severity", "services", "spans", "count", "entries"}
+
+// The Collector for config.Traces. Each entry is tailored as usual and
+// the records go in the trace's entries. Safe for use by multiple
+// goroutines.
+type TraceGrouper struct {
+	t          *Tailor
+	idle       time.Duration
+	service    string
+	maxTraces  int
+	maxEntries int
+
+	mu     sync.Mutex
+	traces map[string]*traceGroup
+	ready  []*traceGroup // output early to make room
+}
+
+type traceGroup struct {
+	trace    string
+	entries  []traceEntry
+	services map[string]bool
+	severity ltype.LogSeverity
+	lastAdd  time.Time
+}
+
+type traceEntry struct {
+	ts       time.Time
+	insertID string
+	spanID   string
+	rec      *Record
+}
+
+func NewTraceGrouper(t *Tailor) *TraceGrouper {
+	tc := t.config.Traces
+	g := &TraceGrouper{t: t, idle: tc.Idle, service: tc.Service, maxTraces: tc.MaxTraces,
+		maxEntries: tc.MaxEntries, traces: map[string]*traceGroup{}}
+	if g.idle <= 0 {
+		g.idle = DefaultTraceIdle
+	}
+	if g.service == "" {
+		g.service = DefaultTraceService
+	}
+	if g.maxTraces <= 0 {
+		g.maxTraces = DefaultTraceMaxTraces
+	}
+	if g.maxEntries <= 0 {
+		g.maxEntries = DefaultTraceMaxEntries
+	}
+	return g
+}
+
+// Tailors the entry and adds it to its trace. The reason is NoTrace,
+// TraceTooBig or why Record failed: script (it was dropped),
+// script-error or missing-field. Errors go to OnError.
+func (g *TraceGrouper) Add(entry *logpb.LogEntry) (bool, string) {
+	return g.addAt(entry, time.Now())
+}
+
+func (g *TraceGrouper) addAt(entry *logpb.LogEntry, now time.Time) (bool, string) {
+	if entry.Trace == "" {
+		return false, NoTrace
+	}
+	rec, err := g.t.Record(entry)
+	if err != nil {
+		return false, recordFailure(g.t, err)
+	}
+	service, _ := g.t.Value(entry, g.service)
+	ts := now
+	if entry.Timestamp != nil {
+		ts = entry.Timestamp.AsTime()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tg := g.traces[entry.Trace]
+	if tg == nil {
+		if len(g.traces) >= g.maxTraces {
+			g.evict()
+		}
+		tg = &traceGroup{trace: entry.Trace, services: map[string]bool{}}
+		g.traces[entry.Trace] = tg
+	}
+	tg.lastAdd = now
+	if len(tg.entries) >= g.maxEntries {
+		return false, TraceTooBig
+	}
+	tg.entries = append(tg.entries, traceEntry{ts: ts, insertID: entry.InsertId, spanID: entry.SpanId, rec: rec})
+	if s := StringValue(service); service != nil && s != "" {
+		tg.services[s] = true
+	}
+	if entry.Severity > tg.severity {
+		tg.severity = entry.Severity
+	}
+	return true, ""
+}
+
+// Reports the error and gives back the reason for it.
+func recordFailure(t *Tailor, err error) string {
+	if errors.Is(err, ErrDropped) {
+		return "script"
+	}
+	t.report(err)
+	var se *ScriptError
+	if errors.As(err, &se) {
+		return "script-error"
+	}
+	return "missing-field"
+}
+
+// Moves the trace that's been idle longest to ready.
+func (g *TraceGrouper) evict() {
+	var oldest *traceGroup
+	for _, tg := range g.traces {
+		if oldest == nil || tg.lastAdd.Before(oldest.lastAdd) {
+			oldest = tg
+		}
+	}
+	delete(g.traces, oldest.trace)
+	g.ready = append(g.ready, oldest)
+}
+
+// Gives back the records for the traces nothing's been added to for the
+// idle time, oldest first.
+func (g *TraceGrouper) Advance(now time.Time) []*Record {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.output(func(tg *traceGroup) bool { return now.Sub(tg.lastAdd) >= g.idle })
+}
+
+// Gives back the records for every trace.
+func (g *TraceGrouper) Close() []*Record {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.output(func(*traceGroup) bool { return true })
+}
+
+func (g *TraceGrouper) output(done func(*traceGroup) bool) []*Record {
+	ready := g.ready
+	g.ready = nil
+	for k, tg := range g.traces {
+		if done(tg) {
+			ready = append(ready, tg)
+			delete(g.traces, k)
+		}
+	}
+	for _, tg := range ready {
+		tg.sort()
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].entries[0].ts.Before(ready[j].entries[0].ts) })
+	var recs []*Record
+	for _, tg := range ready {
+		recs = append(recs, tg.record())
+	}
+	return recs
+}
+
+// Puts the entries in time order (ties by insertId).
+func (tg *traceGroup) sort() {
+	sort.SliceStable(tg.entries, func(i, j int) bool {
+		a, b := tg.entries[i], tg.entries[j]
+		if !a.ts.Equal(b.ts) {
+			return a.ts.Before(b.ts)
+		}
+		return a.insertID < b.insertID
+	})
+}
+
+func (tg *traceGroup) record() *Record {
+	start, end := tg.entries[0].ts, tg.entries[len(tg.entries)-1].ts
+	services := []string{}
+	for s := range tg.services {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	spans := []string{}
+	seen := map[string]bool{}
+	entries := make([]any, len(tg.entries))
+	for i, e := range tg.entries {
+		if e.spanID != "" && !seen[e.spanID] {
+			seen[e.spanID] = true
+			spans = append(spans, e.spanID)
+		}
+		entries[i] = e.rec.Map()
+	}
+
+	rec := &Record{}
+	rec.Set("trace", tg.trace)
+	rec.Set("start", start.Format(time.RFC3339Nano))
+	rec.Set("end", end.Format(time.RFC3339Nano))
+	rec.Set("duration", end.Sub(start).Seconds())
+	rec.Set("severity", tg.severity.String())
+	rec.Set("services", services)
+	rec.Set("spans", spans)
+	rec.Set("count", len(tg.entries))
+	rec.Set("entries", entries)
+	return rec
+}
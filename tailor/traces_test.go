@@ -0,0 +1,95 @@
+package tailor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	ltype "google.golang.org/genproto/googleapis/logging/type"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func runEntry(trace, span, service, id string, at time.Duration, sev ltype.LogSeverity) *logpb.LogEntry {
+	return &logpb.LogEntry{
+		LogName:   "projects/p/logs/run.googleapis.com%2Frequests",
+		InsertId:  id,
+		Trace:     trace,
+		SpanId:    span,
+		Severity:  sev,
+		Timestamp: timestamppb.New(aggStart.Add(at)),
+		Resource: &mrpb.MonitoredResource{
+			Type:   "cloud_run_revision",
+			Labels: map[string]string{"service_name": service},
+		},
+	}
+}
+
+func TestTraces(t *testing.T) {
+	tr := newTestTailor(t, &Config{
+		Common: []OutputMap{{"id": "insertId"}},
+		Traces: &Traces{MaxTraces: 2, MaxEntries: 3},
+	})
+	g := NewTraceGrouper(tr)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	add := func(e *logpb.LogEntry, reason string) {
+		t.Helper()
+		if ok, r := g.addAt(e, now); ok != (reason == "") || r != reason {
+			t.Errorf("adding %s: got %v, %q; want %q", e.InsertId, ok, r, reason)
+		}
+	}
+	add(runEntry("t1", "s2", "api", "b", 2*time.Second, ltype.LogSeverity_INFO), "")
+	add(runEntry("t1", "s1", "frontend", "a", 0, ltype.LogSeverity_DEBUG), "")
+	add(runEntry("t1", "s2", "api", "c", 1500*time.Millisecond, ltype.LogSeverity_ERROR), "")
+	add(runEntry("t1", "s3", "db", "d", 3*time.Second, 0), TraceTooBig)
+	add(runEntry("", "", "api", "e", 0, 0), NoTrace)
+
+	now = now.Add(5 * time.Second)
+	add(runEntry("t2", "", "api", "f", 0, 0), "")
+	if recs := g.Advance(now); len(recs) != 0 {
+		t.Errorf("traces output before they were idle: %v", recs)
+	}
+
+	now = now.Add(DefaultTraceIdle - time.Second)
+	recs := g.Advance(now)
+	if len(recs) != 1 {
+		t.Fatalf("got %d records; want t1", len(recs))
+	}
+	expected := []Field{
+		{Name: "trace", Value: "t1"},
+		{Name: "start", Value: "2024-01-02T03:04:00Z"},
+		{Name: "end", Value: "2024-01-02T03:04:02Z"},
+		{Name: "duration", Value: 2.0},
+		{Name: "severity", Value: "ERROR"},
+		{Name: "services", Value: []string{"api", "frontend"}},
+		{Name: "spans", Value: []string{"s1", "s2"}},
+		{Name: "count", Value: 3},
+		{Name: "entries", Value: []any{
+			map[string]any{"id": "a"},
+			map[string]any{"id": "c"},
+			map[string]any{"id": "b"},
+		}},
+	}
+	if !reflect.DeepEqual(recs[0].Fields, expected) {
+		t.Errorf("record = %v; want %v", recs[0].Fields, expected)
+	}
+	if !reflect.DeepEqual(fieldNames(recs[0]), tr.Columns()) {
+		t.Errorf("columns = %v; want %v", tr.Columns(), fieldNames(recs[0]))
+	}
+
+	// A trace past max-traces makes room by sending out the one idle longest.
+	add(runEntry("t3", "", "api", "g", 0, 0), "")
+	add(runEntry("t4", "", "api", "h", 0, 0), "")
+	recs = g.Advance(now)
+	if len(recs) != 1 {
+		t.Fatalf("got %d records; want t2", len(recs))
+	}
+	if v, _ := recs[0].Get("trace"); v != "t2" {
+		t.Errorf("trace = %v; want t2", v)
+	}
+	if recs = g.Close(); len(recs) != 2 {
+		t.Errorf("Close gave back %d records", len(recs))
+	}
+}
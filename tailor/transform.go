@@ -0,0 +1,302 @@
+package tailor
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One step in an output field's transform: list.
+type transform func(v any) (any, error)
+
+// Output fields can be a path string, a nested map of fields, or a field
+// spec map with a src path plus a regex/value rewrite, a transform list
+// and/or missing:/default: for when there's no value.
+func IsFieldSpec(om OutputMap) bool {
+	if _, ok := om["src"].(string); !ok {
+		return false
+	}
+	return hasKeys(om, "regex", "value") || hasKeys(om, "transform") ||
+		hasKeys(om, "missing") || hasKeys(om, "default")
+}
+
+// Turns the transform: list from the config into functions. Each step is
+// either a bare name (lowercase) or a map with one key (split: ",").
+func compileTransforms(spec any) ([]transform, error) {
+	steps, ok := spec.([]any)
+	if !ok {
+		return nil, fmt.Errorf("transform must be a list, got %T", spec)
+	}
+	var result []transform
+	for _, step := range steps {
+		t, err := compileTransform(step)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+func compileTransform(step any) (transform, error) {
+	switch s := step.(type) {
+	case string:
+		switch s {
+		case "lowercase":
+			return stringTransform(strings.ToLower), nil
+		case "uppercase":
+			return stringTransform(strings.ToUpper), nil
+		case "trim":
+			return stringTransform(strings.TrimSpace), nil
+		case "base64-decode":
+			return base64Decode, nil
+		case "json-parse":
+			return jsonParse, nil
+		}
+		return nil, fmt.Errorf("unknown transform: %s", s)
+	case OutputMap:
+		if len(s) != 1 {
+			return nil, fmt.Errorf("transform step needs exactly one key: %v", s)
+		}
+		name := fieldName(s)
+		arg := s[name]
+		switch name {
+		case "default":
+			return func(v any) (any, error) {
+				if v == nil || v == "" {
+					return arg, nil
+				}
+				return v, nil
+			}, nil
+		case "cast":
+			return compileCast(arg)
+		case "split":
+			sep, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("split needs a string separator")
+			}
+			return func(v any) (any, error) {
+				str, ok := v.(string)
+				if !ok {
+					return v, nil
+				}
+				var parts []any
+				for _, p := range strings.Split(str, sep) {
+					parts = append(parts, p)
+				}
+				return parts, nil
+			}, nil
+		case "join":
+			sep, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("join needs a string separator")
+			}
+			return func(v any) (any, error) {
+				list, ok := v.([]any)
+				if !ok {
+					return v, nil
+				}
+				parts := make([]string, len(list))
+				for i, p := range list {
+					parts[i] = StringValue(p)
+				}
+				return strings.Join(parts, sep), nil
+			}, nil
+		case "substring":
+			return compileSubstring(arg)
+		case "hash":
+			return compileHash(arg)
+		}
+		return nil, fmt.Errorf("unknown transform: %s", name)
+	}
+	return nil, fmt.Errorf("bad transform step: %v", step)
+}
+
+func applyTransforms(v any, transforms []transform) (any, error) {
+	var err error
+	for _, t := range transforms {
+		if v, err = t(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// Non-strings go through untouched.
+func stringTransform(f func(string) string) transform {
+	return func(v any) (any, error) {
+		if s, ok := v.(string); ok {
+			return f(s), nil
+		}
+		return v, nil
+	}
+}
+
+func base64Decode(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return string(b), nil
+		}
+	}
+	return nil, fmt.Errorf("not base64: %q", s)
+}
+
+func jsonParse(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	var result any
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		return nil, fmt.Errorf("json-parse: %v", err)
+	}
+	return result, nil
+}
+
+func compileCast(arg any) (transform, error) {
+	switch arg {
+	case "string":
+		return func(v any) (any, error) {
+			if v == nil {
+				return nil, nil
+			}
+			return StringValue(v), nil
+		}, nil
+	case "int":
+		return func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+					return i, nil
+				}
+			}
+			f, ok := NumericValue(v)
+			if !ok {
+				return nil, fmt.Errorf("can't cast %v to int", v)
+			}
+			return int64(math.Trunc(f)), nil
+		}, nil
+	case "float":
+		return func(v any) (any, error) {
+			f, ok := NumericValue(v)
+			if !ok {
+				return nil, fmt.Errorf("can't cast %v to float", v)
+			}
+			return f, nil
+		}, nil
+	case "bool":
+		return func(v any) (any, error) {
+			switch b := v.(type) {
+			case bool:
+				return b, nil
+			case string:
+				result, err := strconv.ParseBool(strings.TrimSpace(b))
+				if err != nil {
+					return nil, fmt.Errorf("can't cast %q to bool", b)
+				}
+				return result, nil
+			}
+			if f, ok := NumericValue(v); ok {
+				return f != 0, nil
+			}
+			return nil, fmt.Errorf("can't cast %v to bool", v)
+		}, nil
+	case "time":
+		return castTime, nil
+	}
+	return nil, fmt.Errorf("unknown cast: %v (string, int, float, bool, time)", arg)
+}
+
+// Takes RFC3339 strings or epoch seconds/millis and gives back RFC3339Nano UTC.
+func castTime(v any) (any, error) {
+	if s, ok := v.(string); ok {
+		for _, layout := range []string{time.RFC3339Nano, time.RFC3339, time.DateTime, time.DateOnly} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.UTC().Format(time.RFC3339Nano), nil
+			}
+		}
+	}
+	f, ok := NumericValue(v)
+	if !ok {
+		return nil, fmt.Errorf("can't cast %v to time", v)
+	}
+	// Anything this big is millis.
+	if f > 1e11 {
+		return time.UnixMilli(int64(f)).UTC().Format(time.RFC3339Nano), nil
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC().Format(time.RFC3339Nano), nil
+}
+
+// substring: [start, end] or substring: start. Counts runes, not bytes.
+func compileSubstring(arg any) (transform, error) {
+	start, end := 0, -1
+	switch a := arg.(type) {
+	case int:
+		start = a
+	case []any:
+		if len(a) < 1 || len(a) > 2 {
+			return nil, fmt.Errorf("substring needs [start] or [start, end]")
+		}
+		var ok bool
+		if start, ok = a[0].(int); !ok {
+			return nil, fmt.Errorf("substring start must be an int")
+		}
+		if len(a) == 2 {
+			if end, ok = a[1].(int); !ok {
+				return nil, fmt.Errorf("substring end must be an int")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("substring needs [start] or [start, end]")
+	}
+	if start < 0 || (end >= 0 && end < start) {
+		return nil, fmt.Errorf("bad substring range: %v", arg)
+	}
+	return func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		r := []rune(s)
+		from, to := min(start, len(r)), len(r)
+		if end >= 0 {
+			to = min(end, len(r))
+		}
+		return string(r[from:to]), nil
+	}, nil
+}
+
+func compileHash(arg any) (transform, error) {
+	var newHash func() hash.Hash
+	switch arg {
+	case "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	case "md5":
+		newHash = md5.New
+	default:
+		return nil, fmt.Errorf("unknown hash: %v (sha256, sha1, md5)", arg)
+	}
+	return func(v any) (any, error) {
+		if v == nil {
+			return nil, nil
+		}
+		h := newHash()
+		h.Write([]byte(StringValue(v)))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}, nil
+}
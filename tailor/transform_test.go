@@ -0,0 +1,71 @@
+package tailor
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		input    any
+		expected any
+	}{
+		{name: "lowercase and trim", spec: "[lowercase, trim]", input: "  Foo@Example.COM ", expected: "foo@example.com"},
+		{name: "uppercase", spec: "[uppercase]", input: "abc", expected: "ABC"},
+		{name: "default on missing", spec: "[default: unknown]", input: nil, expected: "unknown"},
+		{name: "default on present", spec: "[default: unknown]", input: "x", expected: "x"},
+		{name: "cast int", spec: "[cast: int]", input: "42", expected: int64(42)},
+		{name: "cast float", spec: "[cast: float]", input: "1.5", expected: 1.5},
+		{name: "cast bool", spec: "[cast: bool]", input: "true", expected: true},
+		{name: "cast time", spec: "[cast: time]", input: "2024-01-02T03:04:05+01:00", expected: "2024-01-02T02:04:05Z"},
+		{name: "cast epoch", spec: "[cast: time]", input: 1704164645.0, expected: "2024-01-02T03:04:05Z"},
+		{name: "split", spec: `[split: "@"]`, input: "user@example.com", expected: []any{"user", "example.com"}},
+		{name: "split and join", spec: `[split: "/", join: "."]`, input: "a/b/c", expected: "a.b.c"},
+		{name: "substring", spec: "[substring: [0, 3]]", input: "abcdef", expected: "abc"},
+		{name: "substring past the end", spec: "[substring: [4, 10]]", input: "abcdef", expected: "ef"},
+		{name: "hash", spec: "[hash: sha256]", input: "abc", expected: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{name: "base64", spec: "[base64-decode]", input: "aGVsbG8=", expected: "hello"},
+		{name: "json", spec: "[json-parse]", input: `{"a": [1, 2]}`, expected: map[string]any{"a": []any{1.0, 2.0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wrapper struct {
+				Field OutputMap `yaml:"field"`
+			}
+			if err := yaml.Unmarshal([]byte("field: {transform: "+tt.spec+"}"), &wrapper); err != nil {
+				t.Fatalf("bad spec %s: %v", tt.spec, err)
+			}
+			transforms, err := compileTransforms(wrapper.Field["transform"])
+			if err != nil {
+				t.Fatalf("compileTransforms(%s): %v", tt.spec, err)
+			}
+			got, err := applyTransforms(tt.input, transforms)
+			if err != nil {
+				t.Fatalf("applyTransforms(%v): %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%s on %v = %#v; want %#v", tt.spec, tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBadTransforms(t *testing.T) {
+	specs := []string{"[nope]", "[cast: complex]", "[hash: crc]", "[substring: [3, 1]]", "[split: 1]", "lowercase"}
+	for _, spec := range specs {
+		var wrapper struct {
+			Field OutputMap `yaml:"field"`
+		}
+		if err := yaml.Unmarshal([]byte("field: {transform: "+spec+"}"), &wrapper); err != nil {
+			t.Fatalf("bad spec %s: %v", spec, err)
+		}
+		if _, err := compileTransforms(wrapper.Field["transform"]); err == nil {
+			t.Errorf("compileTransforms(%s) should have failed", spec)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tailor
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func capitalize(s string) string {
+	return strings.ToUpper(string(s[0])) + s[1:]
+}
+
+func hasKeys[K comparable, V any](m map[K]V, ks ...K) bool {
+	for _, k := range ks {
+		if _, ok := m[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func strVal(m OutputMap, k string) string {
+	if v, ok := m[k].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// Given a source string, a regex, and a value output string it returns a
+// value. Example output string: resource-$1-$2
+func regexVal(src string, re *regexp.Regexp, val string) string {
+	m := re.FindStringSubmatch(src)
+	// Only support 9 groups for now.
+	result := val
+	if len(m) > 0 {
+		for i := 1; i <= min(9, len(m)-1); i++ {
+			result = strings.ReplaceAll(result, `$`+strconv.Itoa(i), m[i])
+		}
+	}
+	return result
+}
+
+// Each output item just has one field with a value. The
+// field key is the name.
+func fieldName(outItem OutputMap) string {
+	name := ""
+	for k := range outItem {
+		name = k
+	}
+	return name
+}
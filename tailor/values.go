@@ -0,0 +1,89 @@
+package tailor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Whatever came out of an entry path as a string. nil is "".
+func StringValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// Turns whatever came out of an entry path into a float if it makes sense.
+// Durations (like httpRequest.latency) are in seconds.
+func NumericValue(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+		// protojson renders durations as "1.5s"
+		if strings.HasSuffix(v, "s") {
+			if f, err := strconv.ParseFloat(strings.TrimSuffix(v, "s"), 64); err == nil {
+				return f, true
+			}
+		}
+	case *durationpb.Duration:
+		if v != nil {
+			return v.AsDuration().Seconds(), true
+		}
+	}
+	return 0, false
+}
+
+// Proto messages and typed maps become plain maps so they can be walked.
+func PlainValue(v any) any {
+	switch v := v.(type) {
+	case proto.Message:
+		bytes, err := protojson.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var result any
+		if err := json.Unmarshal(bytes, &result); err != nil {
+			return v
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]any, len(v))
+		for k, s := range v {
+			result[k] = s
+		}
+		return result
+	}
+	return v
+}
@@ -0,0 +1,34 @@
+package tailor
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestNumericValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		expected float64
+		ok       bool
+	}{
+		{name: "float", input: 1.5, expected: 1.5, ok: true},
+		{name: "int32", input: int32(200), expected: 200, ok: true},
+		{name: "numeric string", input: "42", expected: 42, ok: true},
+		{name: "protojson duration", input: "0.25s", expected: 0.25, ok: true},
+		{name: "duration", input: durationpb.New(1500 * time.Millisecond), expected: 1.5, ok: true},
+		{name: "not a number", input: "abc", ok: false},
+		{name: "nil", input: nil, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NumericValue(tt.input)
+			if ok != tt.ok || got != tt.expected {
+				t.Errorf("NumericValue(%v) = %v, %v; want %v, %v", tt.input, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
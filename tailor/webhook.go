@@ -0,0 +1,62 @@
+package tailor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// POSTs each record to a URL as a JSON object. Anything but a 2xx is an
+// error.
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+const DefaultWebhookTimeout = 10 * time.Second
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: DefaultWebhookTimeout}}
+}
+
+func (s *WebhookSink) Open() error {
+	return nil
+}
+
+func (s *WebhookSink) Write(rec *Record) error {
+	body, err := json.Marshal(rec.Map())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// Records are sent as they're written, so there's nothing to flush.
+func (s *WebhookSink) Flush() error {
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package tailor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var got []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer x" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		got = append(got, body)
+	}))
+	defer srv.Close()
+
+	s := NewWebhookSink(srv.URL)
+	rec := &Record{Fields: []Field{{Name: "alert", Value: "iam-burst"}, {Name: "count", Value: 6}}}
+	if err := s.Write(rec); err == nil {
+		t.Errorf("expected an error for a 401")
+	}
+	s.Headers = map[string]string{"Authorization": "Bearer x"}
+	if err := s.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]any{{"alert": "iam-burst", "count": 6.0}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("posted %v; want %v", got, expected)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zonkhead/log-tailor/tailor"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Collects everything wrong with a config instead of stopping at the
+// first thing.
+type configValidator struct {
+	doc      *configDoc
+	problems []configProblem
+}
+
+type configProblem struct {
+	file string
+	line int
+	msg  string
+}
+
+func (p configProblem) String() string {
+	if p.line == 0 {
+		return p.file + ": " + p.msg
+	}
+	return fmt.Sprintf("%s:%d: %s", p.file, p.line, p.msg)
+}
+
+// Decodes the config and checks it. The config is only usable if there
+// are no problems. They come back in file and line order.
+func checkConfig(doc *configDoc) (*Config, []string) {
+	v := &configValidator{doc: doc}
+	var config Config
+	if err := doc.root.Decode(&config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, []string{err.Error()}
+		}
+		for _, e := range typeErr.Errors {
+			// These only have a line, so they go to the file the root is from.
+			p := configProblem{file: doc.file(doc.root), msg: e}
+			if m := yamlLineRE.FindStringSubmatch(e); m != nil {
+				p.line, _ = strconv.Atoi(m[1])
+				p.msg = m[2]
+			}
+			v.problems = append(v.problems, p)
+		}
+	}
+	v.checkKeys(doc.root, reflect.TypeOf(config), "")
+	v.checkValues(doc.root)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.line < b.line
+	})
+	var result []string
+	for _, p := range v.problems {
+		result = append(result, p.String())
+	}
+	return &config, result
+}
+
+var yamlLineRE = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+func (v *configValidator) addf(n *yaml.Node, format string, args ...any) {
+	v.problems = append(v.problems, configProblem{
+		file: v.doc.file(n),
+		line: n.Line,
+		msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Looks for keys the config types don't have, like match_rule.
+func (v *configValidator) checkKeys(n *yaml.Node, t reflect.Type, where string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		var names []string
+		for name := range fields {
+			names = append(names, name)
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			f, ok := fields[k.Value]
+			if !ok {
+				msg := "unknown key " + where + k.Value
+				if s := tailor.Suggest(k.Value, names); s != "" {
+					msg += " (did you mean " + s + "?)"
+				}
+				v.addf(k, "%s", msg)
+				continue
+			}
+			v.checkKeys(n.Content[i+1], f.Type, where+k.Value+".")
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range n.Content {
+			v.checkKeys(item, t.Elem(), where)
+		}
+	}
+}
+
+// The key each field has in yaml.v3: the tag or the lowercased name.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if opts == "inline" && f.Type.Kind() == reflect.Struct {
+			for k, inner := range yamlFields(f.Type) {
+				fields[k] = inner
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// Checks the values in the config, using the nodes so problems have
+// line numbers.
+func (v *configValidator) checkValues(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		v.addf(root, "the config has to be a map")
+		return
+	}
+	if n := mappingValue(root, "match-rule"); n != nil {
+		switch n.Value {
+		case "all", "drop-no-match":
+		default:
+			v.addf(n, "invalid match-rule %q (all or drop-no-match)", n.Value)
+		}
+	}
+	if n := mappingValue(root, "format"); n != nil {
+		switch n.Value {
+		case "jsonl", "yaml", "csv":
+		default:
+			v.addf(n, "invalid format %q (jsonl, yaml or csv)", n.Value)
+		}
+	}
+	if n := mappingValue(root, "missing"); n != nil && !tailor.ValidMissingPolicy(n.Value) {
+		v.addf(n, "invalid missing policy %q (null, omit, default or fail)", n.Value)
+	}
+
+	for _, n := range sequenceItems(mappingValue(root, "common-output")) {
+		v.checkOutput(n)
+	}
+	for _, l := range sequenceItems(mappingValue(root, "logs")) {
+		for _, n := range sequenceItems(mappingValue(l, "output")) {
+			v.checkOutput(n)
+		}
+		v.checkScript(mappingValue(l, "script"))
+		if n := mappingValue(l, "sample"); n != nil {
+			var sample tailor.Sample
+			if n.Decode(&sample) == nil {
+				if err := tailor.CheckSample(&sample); err != nil {
+					v.addf(n, "%v", err)
+				}
+			}
+		}
+		if n := mappingValue(l, "rate-limit"); n != nil {
+			var rl tailor.RateLimit
+			if n.Decode(&rl) == nil {
+				if err := tailor.CheckRateLimit(&rl); err != nil {
+					v.addf(n, "%v", err)
+				}
+			}
+		}
+	}
+	for _, n := range sequenceItems(mappingValue(root, "enrich")) {
+		var e tailor.Enrich
+		if n.Decode(&e) == nil {
+			if err := tailor.CheckEnrich(e); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "geoip"); n != nil {
+		var g tailor.GeoIP
+		if n.Decode(&g) == nil {
+			if err := tailor.CheckGeoIP(&g); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+	v.checkScript(mappingValue(root, "script"))
+	for _, m := range sequenceItems(mappingValue(root, "metrics")) {
+		v.checkMetric(m)
+	}
+	for _, p := range sequenceItems(mappingValue(root, "parsers")) {
+		var def tailor.ParserDef
+		if p.Decode(&def) == nil {
+			if err := tailor.CheckParser(def); err != nil {
+				v.addf(p, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "redact"); n != nil {
+		var r tailor.Redaction
+		if n.Decode(&r) == nil {
+			if err := tailor.CheckRedaction(&r); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "protos"); n != nil {
+		for _, f := range sequenceItems(mappingValue(n, "files")) {
+			if _, err := os.Stat(f.Value); err != nil {
+				v.addf(f, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "sink"); n != nil {
+		v.checkSink(n)
+	}
+	if n := mappingValue(root, "alert-sink"); n != nil {
+		v.checkSink(n)
+	}
+	for _, n := range sequenceItems(mappingValue(root, "alerts")) {
+		var r tailor.AlertRule
+		if n.Decode(&r) == nil {
+			if err := tailor.CheckAlertRule(r); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "aggregate"); n != nil {
+		var a tailor.Aggregate
+		if n.Decode(&a) == nil {
+			if err := tailor.CheckAggregate(&a); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+	if n := mappingValue(root, "traces"); n != nil {
+		if mappingValue(root, "aggregate") != nil {
+			v.addf(n, "aggregate and traces can't both be used")
+		}
+		var tc tailor.Traces
+		if n.Decode(&tc) == nil {
+			if err := tailor.CheckTraces(&tc); err != nil {
+				v.addf(n, "%v", err)
+			}
+		}
+	}
+}
+
+// An output is a path, a field spec or a map of more outputs.
+func (v *configValidator) checkOutput(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v.checkPath(n)
+	case yaml.MappingNode:
+		var om tailor.OutputMap
+		if err := n.Decode(&om); err != nil {
+			return
+		}
+		if !tailor.IsFieldSpec(om) {
+			for i := 1; i < len(n.Content); i += 2 {
+				v.checkOutput(n.Content[i])
+			}
+			return
+		}
+		v.checkPath(mappingValue(n, "src"))
+		regex, hasRegex := om["regex"].(string)
+		if _, hasValue := om["value"]; hasRegex && hasValue {
+			if _, err := regexp.Compile(regex); err != nil {
+				v.addf(mappingValue(n, "regex"), "bad regex: %v", err)
+			}
+		}
+		if spec, ok := om["transform"]; ok {
+			if err := tailor.CheckTransforms(spec); err != nil {
+				v.addf(mappingValue(n, "transform"), "bad transform: %v", err)
+			}
+		}
+		if missing, _ := om["missing"].(string); !tailor.ValidMissingPolicy(missing) {
+			v.addf(mappingValue(n, "missing"), "invalid missing policy %q (null, omit, default or fail)", missing)
+		}
+	}
+}
+
+func (v *configValidator) checkScript(n *yaml.Node) {
+	var s tailor.Script
+	if n == nil || n.Decode(&s) != nil {
+		return
+	}
+	if err := tailor.CheckScript(&s); err != nil {
+		v.addf(n, "%v", err)
+	}
+}
+
+func (v *configValidator) checkSink(n *yaml.Node) {
+	var def SinkDef
+	if n.Decode(&def) != nil {
+		return
+	}
+	switch def.Type {
+	case "", "stdout", "stderr":
+	case "webhook":
+		if def.URL == "" {
+			v.addf(n, "webhook sink needs a url")
+		}
+	case "exec":
+		if len(def.Command) == 0 {
+			v.addf(n, "exec sink needs a command")
+		}
+		switch def.Framing {
+		case "", tailor.JSONLFraming, tailor.ProtobufFraming:
+		default:
+			v.addf(mappingValue(n, "framing"), "invalid framing %q (jsonl or protobuf)", def.Framing)
+		}
+	default:
+		v.addf(mappingValue(n, "type"), "unknown sink type %q (stdout, stderr, exec or webhook)", def.Type)
+	}
+}
+
+func (v *configValidator) checkMetric(n *yaml.Node) {
+	var def MetricDef
+	if n.Decode(&def) != nil {
+		return
+	}
+	if def.Name == "" {
+		v.addf(n, "metric needs a name")
+	}
+	typeNode := mappingValue(n, "type")
+	if typeNode == nil {
+		typeNode = n
+	}
+	switch def.Type {
+	case "counter":
+	case "histogram":
+		if def.Value == "" {
+			v.addf(typeNode, "metric %s: histograms need a value path", def.Name)
+		}
+	default:
+		v.addf(typeNode, "metric %s: unknown type %q (counter or histogram)", def.Name, def.Type)
+	}
+	if labels := mappingValue(n, "labels"); labels != nil && labels.Kind == yaml.MappingNode {
+		for i := 1; i < len(labels.Content); i += 2 {
+			v.checkPath(labels.Content[i])
+		}
+	}
+	if value := mappingValue(n, "value"); value != nil {
+		v.checkPath(value)
+	}
+}
+
+// Paths have to parse and start with something a LogEntry has.
+func (v *configValidator) checkPath(n *yaml.Node) {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return
+	}
+	if err := tailor.CheckPath(n.Value); err != nil {
+		v.addf(n, "%v", err)
+	}
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	if i := mappingIndex(n, key); i >= 0 {
+		return n.Content[i+1]
+	}
+	return nil
+}
+
+func sequenceItems(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+func reportProblems(problems []string) {
+	for _, p := range problems {
+		stderrln(p)
+	}
+}
+
+// The validate command: checks a config without tailing anything.
+func validateMain(argv []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var configs stringList
+	fs.Var(&configs, "config", "Config file (multiple ok, merged in order). Read from stdin if not set")
+	fs.Usage = func() {
+		stderrln("Usage of log-tailor validate:")
+		stderrln("  Checks a config and lists everything wrong with it.\n")
+		stderrln("Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(argv)
+
+	doc, err := loadConfig(configs)
+	if err != nil {
+		stderrln(err.Error())
+		os.Exit(1)
+	}
+	if doc.root == nil {
+		stderrln("No config to validate. Use -config or stdin.")
+		os.Exit(1)
+	}
+	if _, problems := checkConfig(doc); len(problems) > 0 {
+		reportProblems(problems)
+		os.Exit(1)
+	}
+	stderrln("Config is valid.")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"bad.yaml": `match_rule: drop-no-match
+match-rule: some
+projects: [proj]
+limit: lots
+common-output:
+- ts: timestamp
+- name: protopayload.resourceName
+- bad:
+    src: logName
+    regex: "projects/(.*"
+    value: $1
+logs:
+- name: app
+  typ: k8s_cluster
+  output:
+  - user: jsonPayload.user
+  - weird: labels.key(oops
+metrics:
+- name: requests
+  type: gauge
+sink:
+  type: exec
+  framing: xml
+script:
+  source: "def process(:"
+aggregate:
+  window: 1m
+  slide: 5m
+traces:
+  service: resourse.labels.service_name
+enrich:
+- file: nope.csv
+  on: resource.labels.project_id
+  key: project_id
+alert-sink:
+  type: webhook
+alerts:
+- name: iam
+  when: methodName == "SetIamPolicy"
+`,
+	})
+	doc, err := loadConfig([]string{filepath.Join(dir, "bad.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, problems := checkConfig(doc)
+	all := strings.Join(problems, "\n")
+
+	expected := []string{
+		"bad.yaml:1: unknown key match_rule (did you mean match-rule?)",
+		`bad.yaml:2: invalid match-rule "some"`,
+		"bad.yaml:4: cannot unmarshal",
+		"bad.yaml:7: protopayload.resourceName doesn't start with a LogEntry field (did you mean protoPayload?)",
+		"bad.yaml:10: bad regex",
+		"bad.yaml:14: unknown key logs.typ (did you mean type?)",
+		"bad.yaml:17: Parse error on key()",
+		`bad.yaml:20: metric requests: unknown type "gauge"`,
+		"bad.yaml:22: exec sink needs a command",
+		`bad.yaml:23: invalid framing "xml"`,
+		"bad.yaml:25: script: script:1:14: got ':'",
+		"bad.yaml:27: aggregate slide 5m0s isn't between 0 and the window",
+		"bad.yaml:30: aggregate and traces can't both be used",
+		"bad.yaml:30: resourse.labels.service_name doesn't start with a LogEntry field (did you mean resource?)",
+		"bad.yaml:32: enrich: stat nope.csv: no such file or directory",
+		"bad.yaml:36: webhook sink needs a url",
+		"bad.yaml:38: alert iam: when: methodName doesn't start with a LogEntry field",
+	}
+	for _, e := range expected {
+		if !strings.Contains(all, e) {
+			t.Errorf("expected a problem like %q in:\n%s", e, all)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("got %d problems; want %d:\n%s", len(problems), len(expected), all)
+	}
+}
+
+func TestCheckConfigValid(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"good.yaml": `match-rule: drop-no-match
+projects: [proj]
+common-output:
+- timestamp: timestamp
+- log-name:
+    src: logName
+    regex: projects/.*?/logs/(.*)
+    value: $1
+- labels: resource.labels
+logs:
+- name: cloudaudit.googleapis.com/activity
+  type: k8s_cluster
+  output:
+  - principal: protoPayload.authenticationInfo.principalEmail
+  - http:
+      status: httpRequest.status
+      trace: trace
+  sample:
+    ratio: 0.1
+  rate-limit:
+    rate: 5
+    key: protoPayload.authenticationInfo.principalEmail
+dedup:
+  max-entries: 10
+sink:
+  type: exec
+  command: [./to-bigquery, --dataset, logs]
+  framing: protobuf
+alert-sink:
+  type: webhook
+  url: https://hooks.example.com/alerts
+  headers:
+    Authorization: Bearer $ALERT_TOKEN
+alerts:
+- name: iam-policy-burst
+  when: protoPayload.methodName == "SetIamPolicy"
+  by:
+  - principal: protoPayload.authenticationInfo.principalEmail
+  threshold: 5
+  window: 10m
+  cooldown: 30m
+aggregate:
+  window: 5m
+  slide: 1m
+  group-by:
+  - project: resource.labels.project_id
+  - method: protoPayload.methodName
+  - code: protoPayload.status.code
+  values:
+  - latency: httpRequest.latency
+`,
+	})
+	doc, err := loadConfig([]string{filepath.Join(dir, "good.yaml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, problems := checkConfig(doc); len(problems) > 0 {
+		t.Errorf("expected no problems, got:\n%s", strings.Join(problems, "\n"))
+	}
+}